@@ -50,134 +50,240 @@ const (
 	Expired = EventType("expire")
 )
 
-var out chan AgeAdvisoryV1
-
-var seen map[string]time.Time
-var advised map[string]time.Time
-
-var mu = &sync.Mutex{}
-var configured = false
-var conf *config.TopicConf
-var interval time.Duration
-var age time.Duration
-var err error
-var log *logrus.Entry
-var conn stream
-var natstls bool
-var name string
-
-func init() {
-	reset()
+// Advisor tracks when inspected values were last seen and publishes
+// advisories about the ones that stopped being seen, each replicated
+// topic has its own advisor
+type Advisor struct {
+	out        chan AgeAdvisoryV1
+	seen       map[string]time.Time
+	advised    map[string]time.Time
+	mu         sync.Mutex
+	configured bool
+	conf       *config.TopicConf
+	interval   time.Duration
+	age        time.Duration
+	log        *logrus.Entry
+	conn       stream
+	natstls    bool
+	name       string
+
+	// pmu protects the state used by the publisher, it is never held while
+	// taking mu so advisories are published while mu is held sending on out
+	pmu sync.Mutex
+
+	// subject is where advisories are published
+	subject string
+
+	// failing is when publishing advisories started failing, zero while publishing works
+	failing time.Time
+}
+
+// New creates and configures an advisor for a topic
+func New(tls bool, c *config.TopicConf) (*Advisor, error) {
+	a := &Advisor{}
+
+	return a, a.Configure(tls, c)
 }
 
 // Configure configures the advisor
-func Configure(tls bool, c *config.TopicConf) error {
-	mu.Lock()
-	defer mu.Unlock()
+func (a *Advisor) Configure(tls bool, c *config.TopicConf) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	conf = c
-	name = fmt.Sprintf("%s_advisor", c.Name)
-	log = logrus.WithFields(logrus.Fields{"name": name})
+	a.reset()
 
-	if conf.Advisory == nil {
-		log.Warn("No advisory settings configured, disabling advisory publishing")
+	a.conf = c
+	a.name = fmt.Sprintf("%s_advisor", c.Name)
+	a.log = logrus.WithFields(logrus.Fields{"name": a.name})
+
+	if a.conf.Advisory == nil {
+		a.log.Warn("No advisory settings configured, disabling advisory publishing")
 		return nil
 	}
 
-	natstls = tls
+	a.natstls = tls
+	a.setTarget(c.Advisory.Target)
+
+	var err error
+
+	a.age, err = time.ParseDuration(c.Advisory.Age)
+	if err != nil {
+		return fmt.Errorf("age cannot be parsed as a duration: %s", err)
+	}
+
+	a.interval, err = time.ParseDuration(c.MinAge)
+	if err != nil {
+		return fmt.Errorf("topic min age cannot be parsed as a duration: %s", err)
+	}
+
+	a.configured = true
+
+	return nil
+}
 
-	age, err = time.ParseDuration(c.Advisory.Age)
+// Update applies new advisory ages from the configuration while running, the
+// advisory cluster cannot be changed without reconnecting
+func (a *Advisor) Update(c *config.TopicConf) error {
+	if c.Advisory == nil {
+		return fmt.Errorf("no advisory settings configured")
+	}
+
+	age, err := time.ParseDuration(c.Advisory.Age)
 	if err != nil {
 		return fmt.Errorf("age cannot be parsed as a duration: %s", err)
 	}
 
-	interval, err = time.ParseDuration(c.MinAge)
+	interval, err := time.ParseDuration(c.MinAge)
 	if err != nil {
 		return fmt.Errorf("topic min age cannot be parsed as a duration: %s", err)
 	}
 
-	reset()
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	configured = true
+	if a.conf.Advisory != nil && a.conf.Advisory.Cluster != c.Advisory.Cluster {
+		return fmt.Errorf("the advisory cluster cannot be changed while running")
+	}
+
+	a.conf = c
+	a.age = age
+	a.interval = interval
+	a.setTarget(c.Advisory.Target)
 
 	return nil
 }
 
+// target is the subject advisories are published to
+func (a *Advisor) target() string {
+	a.pmu.Lock()
+	defer a.pmu.Unlock()
+
+	return a.subject
+}
+
+func (a *Advisor) setTarget(subject string) {
+	a.pmu.Lock()
+	a.subject = subject
+	a.pmu.Unlock()
+}
+
 // Connect initiates the connection to NATS Streaming
-func Connect(ctx context.Context, wg *sync.WaitGroup) {
-	mu.Lock()
-	defer mu.Unlock()
+func (a *Advisor) Connect(ctx context.Context, wg *sync.WaitGroup) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	if !configured {
+	if !a.configured {
 		return
 	}
 
-	log.Debug("Starting advisor connection")
-	connect(ctx)
+	a.log.Debug("Starting advisor connection")
+	a.connect(ctx)
 
-	log.Debug("Starting advisor publisher")
+	a.log.Debug("Starting advisor publisher")
 	wg.Add(1)
-	go publisher(ctx, wg)
+	go a.publisher(ctx, wg)
 
-	log.Debug("Starting advisor monitor")
+	a.log.Debug("Starting advisor monitor")
 	wg.Add(1)
-	go monitor(ctx, wg)
+	go a.monitor(ctx, wg)
+}
+
+// State is a summary of the values an advisor is tracking
+type State struct {
+	Seen         int        `json:"seen"`
+	Advised      int        `json:"advised"`
+	Pending      int        `json:"pending"`
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+}
+
+// State reports how many values are being tracked, advised about and waiting to be published
+func (a *Advisor) State() State {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	s := State{
+		Seen:    len(a.seen),
+		Advised: len(a.advised),
+		Pending: len(a.out),
+	}
+
+	a.pmu.Lock()
+	if !a.failing.IsZero() {
+		failing := a.failing
+		s.FailingSince = &failing
+	}
+	a.pmu.Unlock()
+
+	return s
+}
+
+// published records the outcome of publishing an advisory
+func (a *Advisor) published(err error) {
+	a.pmu.Lock()
+	defer a.pmu.Unlock()
+
+	switch {
+	case err == nil:
+		a.failing = time.Time{}
+	case a.failing.IsZero():
+		a.failing = time.Now()
+	}
 }
 
 // Record records the fact that a node was seen
-func Record(id string) {
-	RecordTime(id, time.Now())
+func (a *Advisor) Record(id string) {
+	a.RecordTime(id, time.Now())
 }
 
 // RecordTime records that a sender was seen at a specific time
-func RecordTime(id string, seent time.Time) {
-	mu.Lock()
-	defer mu.Unlock()
+func (a *Advisor) RecordTime(id string, seent time.Time) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	if !configured {
+	if !a.configured {
 		return
 	}
 
 	// the limiters will have thresholds and grace periods etc,
 	// we have none so if they send us old stuff just drop it
-	if seent.Before(oldest()) {
+	if seent.Before(a.oldest()) {
 		return
 	}
 
 	// we previously advised about this node, so
 	// its back now lets advise about it and delete
 	// the advisory record
-	t, ok := advised[id]
+	t, ok := a.advised[id]
 	if ok {
-		log.Infof("sending advisory: %s: returned after previous advisory at %v", id, t)
-		recoverAdvisoryCtr.WithLabelValues(name).Inc()
+		a.log.Infof("sending advisory: %s: returned after previous advisory at %v", id, t)
+		recoverAdvisoryCtr.WithLabelValues(a.name).Inc()
 
-		out <- newAdvisory(id, Recovery)
-		delete(advised, id)
+		a.out <- a.newAdvisory(id, Recovery)
+		delete(a.advised, id)
 	}
 
-	log.Debugf("Recorded %s as seen at %v", id, seent)
+	a.log.Debugf("Recorded %s as seen at %v", id, seent)
 
-	seen[id] = seent
+	a.seen[id] = seent
 }
 
 // once a minute goes runs the adviser
-func monitor(ctx context.Context, wg *sync.WaitGroup) {
+func (a *Advisor) monitor(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	if !configured {
+	if !a.configured {
 		return
 	}
 
-	log.Debug("Starting advisor monitor")
+	a.log.Debug("Starting advisor monitor")
 
 	ticker := time.NewTicker(30 * time.Second)
 
 	for {
 		select {
 		case <-ticker.C:
-			log.Debug("Starting advisory loop")
-			advise()
+			a.log.Debug("Starting advisory loop")
+			a.advise()
 		case <-ctx.Done():
 			return
 		}
@@ -186,91 +292,92 @@ func monitor(ctx context.Context, wg *sync.WaitGroup) {
 
 // goes through all the nodes in the seen list, find the ones
 // last seen > the advisery trigger time sends an advisory for them
-func advise() {
-	mu.Lock()
-	defer mu.Unlock()
+func (a *Advisor) advise() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	if !configured {
+	if !a.configured {
 		return
 	}
 
-	timeout := time.Now().Add(0 - age)
-	expire := time.Now().Add(0 - interval)
+	timeout := time.Now().Add(0 - a.age)
+	expire := time.Now().Add(0 - a.interval)
 
-	log.Debugf("Looking for nodes last seen earlier than %v", timeout)
+	a.log.Debugf("Looking for nodes last seen earlier than %v", timeout)
 
-	for i, t := range seen {
+	for i, t := range a.seen {
 		if t.Before(expire) {
-			log.Infof("sending advisory: %s: expiring", i)
-			expiredAdvisoryCtr.WithLabelValues(name).Inc()
+			a.log.Infof("sending advisory: %s: expiring", i)
+			expiredAdvisoryCtr.WithLabelValues(a.name).Inc()
 
-			out <- newAdvisory(i, Expired)
+			a.out <- a.newAdvisory(i, Expired)
 
-			delete(seen, i)
-			delete(advised, i)
+			delete(a.seen, i)
+			delete(a.advised, i)
 
 			continue
 		}
 
 		if t.Before(timeout) {
-			_, found := advised[i]
+			_, found := a.advised[i]
 
 			if !found {
-				advisory := newAdvisory(i, Timeout)
+				advisory := a.newAdvisory(i, Timeout)
 
-				log.Infof("sending advisory: %s: older than %v, last seen %d seconds ago", i, timeout, advisory.Age)
-				timeoutAdvisoryCtr.WithLabelValues(name).Inc()
+				a.log.Infof("sending advisory: %s: older than %v, last seen %d seconds ago", i, timeout, advisory.Age)
+				timeoutAdvisoryCtr.WithLabelValues(a.name).Inc()
 
-				out <- advisory
-				advised[i] = time.Now()
+				a.out <- advisory
+				a.advised[i] = time.Now()
 			}
 		}
 	}
 }
 
-func newAdvisory(id string, event EventType) AgeAdvisoryV1 {
+func (a *Advisor) newAdvisory(id string, event EventType) AgeAdvisoryV1 {
 	return AgeAdvisoryV1{
 		Timestamp:  time.Now().UTC().Unix(),
-		Age:        time.Now().Unix() - seen[id].Unix(),
-		Inspect:    conf.Inspect,
-		Replicator: conf.Name,
-		Seen:       seen[id].Unix(),
+		Age:        time.Now().Unix() - a.seen[id].Unix(),
+		Inspect:    a.conf.Inspect,
+		Replicator: a.conf.Name,
+		Seen:       a.seen[id].Unix(),
 		Value:      id,
 		Event:      event,
 		Version:    "https://choria.io/schemas/sr/v1/age_advisory.json",
 	}
 }
 
-func connect(ctx context.Context) {
-	if conf.Advisory.Cluster == "source" {
-		log.Infof("Connection to source to publish advisories")
-		conn = connector.New(name, natstls, connector.Source, conf, log)
+func (a *Advisor) connect(ctx context.Context) {
+	if a.conf.Advisory.Cluster == "source" {
+		a.log.Infof("Connection to source to publish advisories")
+		a.conn = connector.NewStream(a.name, a.natstls, connector.Source, a.conf, a.log)
 	} else {
-		log.Infof("Connection to target to publish advisories")
-		conn = connector.New(name, natstls, connector.Target, conf, log)
+		a.log.Infof("Connection to target to publish advisories")
+		a.conn = connector.NewStream(a.name, a.natstls, connector.Target, a.conf, a.log)
 	}
 
-	conn.Connect(ctx)
+	a.conn.Connect(ctx)
 }
 
-func publisher(ctx context.Context, wg *sync.WaitGroup) {
+func (a *Advisor) publisher(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for {
 		select {
-		case msg := <-out:
+		case msg := <-a.out:
 			d, err := json.Marshal(msg)
 			if err != nil {
-				log.Errorf("Cannot publish advisory: %s", err)
-				publishErrCtr.WithLabelValues(name).Inc()
+				a.log.Errorf("Cannot publish advisory: %s", err)
+				publishErrCtr.WithLabelValues(a.name).Inc()
 				continue
 			}
 
 			for i := 0; i < 10; i++ {
-				err := conn.Publish(conf.Advisory.Target, d)
+				err := a.conn.Publish(a.target(), d)
+				a.published(err)
 				if err != nil {
-					log.Warnf("Failed to publish %s advisory for %s: %s", msg.Event, msg.Value, err)
-					publishErrCtr.WithLabelValues(name).Inc()
+					a.log.Warnf("Failed to publish %s advisory for %s: %s", msg.Event, msg.Value, err)
+					publishErrCtr.WithLabelValues(a.name).Inc()
 
 					if i < 9 {
 						if backoff.FiveSec.InterruptableSleep(ctx, i) != nil {
@@ -285,20 +392,20 @@ func publisher(ctx context.Context, wg *sync.WaitGroup) {
 			}
 
 		case <-ctx.Done():
-			log.Infof("Advisor shutting down")
-			conn.Close()
+			a.log.Infof("Advisor shutting down")
+			a.conn.Close()
 			return
 		}
 	}
 }
 
-func reset() {
-	out = make(chan AgeAdvisoryV1, 1000)
-	seen = make(map[string]time.Time)
-	advised = make(map[string]time.Time)
-	configured = false
+func (a *Advisor) reset() {
+	a.out = make(chan AgeAdvisoryV1, 1000)
+	a.seen = make(map[string]time.Time)
+	a.advised = make(map[string]time.Time)
+	a.configured = false
 }
 
-func oldest() time.Time {
-	return time.Now().Add(0 - age)
+func (a *Advisor) oldest() time.Time {
+	return time.Now().Add(0 - a.age)
 }
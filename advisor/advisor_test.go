@@ -2,11 +2,15 @@ package advisor
 
 import (
 	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/choria-io/stream-replicator/config"
 	conntest "github.com/choria-io/stream-replicator/connector/test"
+	nats "github.com/nats-io/nats.go"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 )
@@ -16,15 +20,29 @@ func TestFederation(t *testing.T) {
 	RunSpecs(t, "Advisor")
 }
 
+type stubStream struct {
+	published int64
+}
+
+func (s *stubStream) Connect(ctx context.Context) {}
+func (s *stubStream) Close() error                { return nil }
+func (s *stubStream) NatsConn() *nats.Conn        { return nil }
+
+func (s *stubStream) Publish(subject string, data []byte) error {
+	atomic.AddInt64(&s.published, 1)
+	return nil
+}
+
 var _ = Describe("Advisor", func() {
 	var (
 		ctx      context.Context
 		goodconf *config.TopicConf
+		a        *Advisor
 	)
 
 	BeforeEach(func() {
 		ctx = context.Background()
-		reset()
+		a = &Advisor{}
 
 		goodconf = &config.TopicConf{
 			SourceID:  "left",
@@ -44,7 +62,7 @@ var _ = Describe("Advisor", func() {
 
 	var _ = Describe("connect", func() {
 		BeforeEach(func() {
-			err := Configure(false, goodconf)
+			err := a.Configure(false, goodconf)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
@@ -59,10 +77,10 @@ var _ = Describe("Advisor", func() {
 			left := conntest.RunLeftServer("nats://localhost:34222")
 			defer left.Shutdown()
 
-			conf.Advisory.Cluster = "source"
-			connect(ctx)
+			a.conf.Advisory.Cluster = "source"
+			a.connect(ctx)
 
-			Expect(conn.NatsConn().ConnectedUrl()).To(Equal("nats://localhost:34222"))
+			Expect(a.conn.NatsConn().ConnectedUrl()).To(Equal("nats://localhost:34222"))
 		})
 
 		It("Should connect to the target server when configured", func() {
@@ -76,17 +94,17 @@ var _ = Describe("Advisor", func() {
 			right := conntest.RunRightServer("nats://localhost:44222")
 			defer right.Shutdown()
 
-			conf.Advisory.Cluster = "target"
-			connect(ctx)
+			a.conf.Advisory.Cluster = "target"
+			a.connect(ctx)
 
-			Expect(conn.NatsConn().ConnectedUrl()).To(Equal("nats://localhost:44222"))
+			Expect(a.conn.NatsConn().ConnectedUrl()).To(Equal("nats://localhost:44222"))
 		})
 	})
 
 	var _ = Describe("Configure", func() {
 		It("Should be a noop when no advisory is configured", func() {
-			Configure(false, &config.TopicConf{})
-			Expect(configured).To(BeFalse())
+			a.Configure(false, &config.TopicConf{})
+			Expect(a.configured).To(BeFalse())
 		})
 
 		It("Should handle invalid times", func() {
@@ -96,49 +114,94 @@ var _ = Describe("Advisor", func() {
 				},
 			}
 
-			err := Configure(true, c)
+			err := a.Configure(true, c)
 			Expect(err).To(MatchError("age cannot be parsed as a duration: time: invalid duration \"x\""))
-			Expect(configured).To(BeFalse())
+			Expect(a.configured).To(BeFalse())
 		})
 
 		It("Should mark it as configured on success", func() {
-			err := Configure(true, goodconf)
+			err := a.Configure(true, goodconf)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(a.configured).To(BeTrue())
+			Expect(a.name).To(Equal("testing_advisor"))
+		})
+	})
+
+	var _ = Describe("State", func() {
+		It("Should count seen and advised values", func() {
+			err := a.Configure(true, goodconf)
+			Expect(err).ToNot(HaveOccurred())
+			a.Record("test")
+			a.advised["other"] = time.Now()
+
+			Expect(a.State()).To(Equal(State{Seen: 1, Advised: 1}))
+		})
+
+		It("Should report when publishing is failing", func() {
+			a.published(fmt.Errorf("test error"))
+			since := a.State().FailingSince
+			Expect(since).ToNot(BeNil())
+
+			a.published(fmt.Errorf("test error"))
+			Expect(a.State().FailingSince).To(Equal(since))
+
+			a.published(nil)
+			Expect(a.State().FailingSince).To(BeNil())
+		})
+	})
+
+	var _ = Describe("Update", func() {
+		It("Should update the ages and target", func() {
+			err := a.Configure(true, goodconf)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(configured).To(BeTrue())
-			Expect(name).To(Equal("testing_advisor"))
+			a.Record("test")
+
+			update := *goodconf
+			update.MinAge = "3h"
+			update.Advisory = &config.AdvisoryConf{Age: "30m", Cluster: "source", Target: "other.target"}
+
+			Expect(a.Update(&update)).To(Succeed())
+			Expect(a.age).To(Equal(30 * time.Minute))
+			Expect(a.interval).To(Equal(3 * time.Hour))
+			Expect(a.target()).To(Equal("other.target"))
+			Expect(a.seen).To(HaveLen(1))
+
+			moved := update
+			moved.Advisory = &config.AdvisoryConf{Age: "30m", Cluster: "target"}
+			Expect(a.Update(&moved)).To(MatchError("the advisory cluster cannot be changed while running"))
 		})
 	})
 
 	var _ = Describe("Record", func() {
 		It("Should noop when not configured", func() {
-			Expect(seen).To(BeEmpty())
-			Record("test")
-			Expect(seen).To(BeEmpty())
+			Expect(a.seen).To(BeEmpty())
+			a.Record("test")
+			Expect(a.seen).To(BeEmpty())
 		})
 
 		It("Should record the sender", func() {
-			err := Configure(true, goodconf)
+			err := a.Configure(true, goodconf)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(seen).To(BeEmpty())
-			Record("test")
-			Expect(seen).To(HaveLen(1))
+			Expect(a.seen).To(BeEmpty())
+			a.Record("test")
+			Expect(a.seen).To(HaveLen(1))
 		})
 
 		It("Should send advisories if this is a previously advised about sender", func() {
-			err := Configure(true, goodconf)
+			err := a.Configure(true, goodconf)
 			Expect(err).ToNot(HaveOccurred())
-			Expect(out).To(HaveLen(0))
+			Expect(a.out).To(HaveLen(0))
 
-			Record("test")
-			Expect(seen).To(HaveLen(1))
-			advised["test"] = time.Now().UTC()
+			a.Record("test")
+			Expect(a.seen).To(HaveLen(1))
+			a.advised["test"] = time.Now().UTC()
 
-			Record("test")
+			a.Record("test")
 
-			Expect(out).To(HaveLen(1))
-			Expect(advised).To(HaveLen(0))
+			Expect(a.out).To(HaveLen(1))
+			Expect(a.advised).To(HaveLen(0))
 
-			msg := <-out
+			msg := <-a.out
 			Expect(msg.Value).To(Equal("test"))
 			Expect(msg.Event).To(Equal(Recovery))
 		})
@@ -146,34 +209,66 @@ var _ = Describe("Advisor", func() {
 
 	var _ = Describe("advise", func() {
 		It("Should advise all senders not seen in the configured time", func() {
-			err := Configure(true, goodconf)
+			err := a.Configure(true, goodconf)
 			Expect(err).ToNot(HaveOccurred())
 
-			Expect(seen).To(BeEmpty())
+			Expect(a.seen).To(BeEmpty())
 
-			seen["old"] = time.Now().Add(-1 * time.Hour)
-			seen["expired"] = time.Now().Add(-3 * time.Hour)
-			seen["new"] = time.Now()
+			a.seen["old"] = time.Now().Add(-1 * time.Hour)
+			a.seen["expired"] = time.Now().Add(-3 * time.Hour)
+			a.seen["new"] = time.Now()
 
-			Expect(out).To(HaveLen(0))
+			Expect(a.out).To(HaveLen(0))
 
-			advise()
+			a.advise()
 
-			Expect(out).To(HaveLen(2))
+			Expect(a.out).To(HaveLen(2))
 
-			msg := <-out
+			msg := <-a.out
 			Expect(msg.Value).To(Equal("old"))
 			Expect(msg.Event).To(Equal(Timeout))
 
-			msg = <-out
+			msg = <-a.out
 			Expect(msg.Value).To(Equal("expired"))
 			Expect(msg.Event).To(Equal(Expired))
 
-			_, found := advised["old"]
+			_, found := a.advised["old"]
 			Expect(found).To(BeTrue())
 
-			_, found = advised["expired"]
+			_, found = a.advised["expired"]
 			Expect(found).To(BeFalse())
 		})
+
+		It("Should publish while advising more values than fit in the queue", func() {
+			err := a.Configure(true, goodconf)
+			Expect(err).ToNot(HaveOccurred())
+
+			for i := 0; i < 2*cap(a.out); i++ {
+				a.seen[fmt.Sprintf("node%d", i)] = time.Now().Add(-3 * time.Hour)
+			}
+
+			conn := &stubStream{}
+			a.conn = conn
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			wg := &sync.WaitGroup{}
+			wg.Add(1)
+			go a.publisher(ctx, wg)
+
+			done := make(chan struct{})
+			go func() {
+				a.advise()
+				close(done)
+			}()
+
+			Eventually(done, 5*time.Second).Should(BeClosed())
+			Eventually(func() int64 { return atomic.LoadInt64(&conn.published) }, 5*time.Second).Should(BeNumerically("==", 2*cap(a.out)))
+			Expect(a.State().Seen).To(Equal(0))
+
+			cancel()
+			wg.Wait()
+		})
 	})
 })
@@ -21,13 +21,13 @@ import (
 )
 
 var (
-	rep     = replicator.Copier{}
 	cancel  func()
 	ctx     context.Context
 	version = "unknown"
 
 	cfile   string
 	topic   string
+	all     bool
 	pidfile string
 
 	enrollIdentity string
@@ -44,9 +44,13 @@ func Run() {
 	replicate.Default()
 
 	replicate.Flag("config", "Configuration file").StringVar(&cfile)
-	replicate.Flag("topic", "Topic to replicate").Required().StringVar(&topic)
+	replicate.Flag("topic", "Topic to replicate").StringVar(&topic)
+	replicate.Flag("all", "Replicate all configured topics").BoolVar(&all)
 	replicate.Flag("pid", "Write running PID to a file").StringVar(&pidfile)
 
+	validate := app.Command("validate", "Validates a configuration file")
+	validate.Flag("config", "Configuration file").Required().StringVar(&cfile)
+
 	enroll := app.Command("enroll", "Enrolls with a Puppet CA")
 	enroll.Arg("identity", "Certificate Name to use when enrolling").StringVar(&enrollIdentity)
 	enroll.Flag("ca", "Host and port for the Puppet CA in host:port format").Default("puppet:8140").StringVar(&enrollCA)
@@ -60,6 +64,8 @@ func Run() {
 	switch command {
 	case "replicate":
 		runReplicate()
+	case "validate":
+		runValidate()
 	default:
 		runEnroll()
 	}
@@ -101,8 +107,43 @@ func runEnroll() {
 	}
 }
 
+func runValidate() {
+	problems := []string{}
+
+	err := config.Load(cfile)
+	if verr, ok := err.(*config.ValidationError); ok {
+		problems = verr.Problems
+	} else if err != nil {
+		fmt.Printf("Could not load configuration file %s: %s\n", cfile, err)
+		os.Exit(1)
+	}
+
+	// the replicator setup performs checks that need more than the file, like compiling filters
+	topics := config.TopicNames()
+	if len(problems) == 0 {
+		for _, name := range topics {
+			topicconf, _ := config.Topic(name)
+
+			err = (&replicator.Copier{}).Setup(name, topicconf)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("topic %s: %s", name, err))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		fmt.Printf("Configuration file %s has %d problem(s):\n\n", cfile, len(problems))
+		for _, p := range problems {
+			fmt.Printf("  %s\n", p)
+		}
+
+		os.Exit(1)
+	}
+
+	fmt.Printf("Configuration file %s is valid with %d topic(s): %s\n", cfile, len(topics), strings.Join(topics, ", "))
+}
+
 func runReplicate() {
-	done := make(chan int, 1)
 	wg := &sync.WaitGroup{}
 
 	err := config.Load(cfile)
@@ -113,19 +154,62 @@ func runReplicate() {
 
 	configureLogging()
 
-	topicconf, err := config.Topic(topic)
+	err = replicator.SetLatencyBuckets(config.LatencyBuckets())
 	if err != nil {
-		logrus.Fatalf("Could not find a configuration for topic %s in the config file %s", topic, cfile)
+		logrus.Fatalf("Could not configure latency buckets: %s", err)
+		os.Exit(1)
+	}
+
+	if all == (topic != "") {
+		logrus.Fatalf("Either a topic or --all is required")
 		os.Exit(1)
 	}
 
-	go interruptHandler()
+	topics := []string{topic}
+	port := 0
+
+	if all {
+		topics = config.TopicNames()
+		port = config.MonitorPort()
+	}
+
+	confs := make(map[string]*config.TopicConf)
+	for _, name := range topics {
+		topicconf, err := config.Topic(name)
+		if err != nil {
+			logrus.Fatalf("Could not find a configuration for topic %s in the config file %s", name, cfile)
+			os.Exit(1)
+		}
+
+		confs[name] = topicconf
+
+		if !all {
+			port = topicconf.MonitorPort
+		}
+	}
+
+	go interruptHandler(wg)
 
 	writePID(pidfile)
 
-	logrus.Infof("Starting Choria Stream Replicator version %s for topic %s with configuration file %s", version, topic, cfile)
+	logrus.Infof("Starting Choria Stream Replicator version %s for topics %s with configuration file %s", version, strings.Join(topics, ", "), cfile)
 
-	startReplicator(ctx, wg, done, topicconf, topic)
+	for _, name := range topics {
+		startReplicator(ctx, wg, confs[name], name)
+	}
+
+	if port > 0 {
+		replicator.SetupStatus(runningCopiers)
+		replicator.SetupAdmin(runningCopiers)
+
+		err = replicator.SetupHealth(runningCopiers, config.Health())
+		if err != nil {
+			logrus.Fatalf("Could not configure health checks: %s", err)
+			os.Exit(1)
+		}
+
+		go replicator.SetupPrometheus(port)
+	}
 
 	wg.Wait()
 }
@@ -142,13 +226,18 @@ func writePID(pidfile string) {
 	}
 }
 
-func interruptHandler() {
+func interruptHandler(wg *sync.WaitGroup) {
 	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
 	for {
 		select {
 		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				reload(wg)
+				continue
+			}
+
 			logrus.Infof("Shutting down on %s", sig)
 			cancel()
 		case <-ctx.Done():
@@ -157,21 +246,6 @@ func interruptHandler() {
 	}
 }
 
-func startReplicator(ctx context.Context, wg *sync.WaitGroup, done chan int, topic *config.TopicConf, topicname string) {
-	err := rep.Setup(topicname, topic)
-	if err != nil {
-		logrus.Errorf("Could not configure Replicator: %s", err)
-		return
-	}
-
-	if topic.MonitorPort > 0 {
-		go rep.SetupPrometheus(topic.MonitorPort)
-	}
-
-	wg.Add(1)
-	go rep.Run(ctx, wg)
-}
-
 func configureLogging() {
 	if config.LogFile() != "" {
 		logrus.SetFormatter(&logrus.JSONFormatter{})
@@ -185,6 +259,10 @@ func configureLogging() {
 		logrus.SetOutput(file)
 	}
 
+	configureLogLevel()
+}
+
+func configureLogLevel() {
 	logrus.SetLevel(logrus.InfoLevel)
 
 	if config.Verbose() {
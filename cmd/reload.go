@@ -0,0 +1,161 @@
+package cmd
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/replicator"
+	"github.com/sirupsen/logrus"
+)
+
+// replication is a running copier that can be stopped without affecting other topics
+type replication struct {
+	copier *replicator.Copier
+	cancel func()
+	done   chan struct{}
+}
+
+var (
+	replications = make(map[string]*replication)
+	repmu        sync.Mutex
+)
+
+func startReplicator(ctx context.Context, wg *sync.WaitGroup, topic *config.TopicConf, topicname string) {
+	rep := &replicator.Copier{}
+
+	err := rep.Setup(topicname, topic)
+	if err != nil {
+		logrus.Errorf("Could not configure Replicator for topic %s: %s", topicname, err)
+		return
+	}
+
+	rctx, cancel := context.WithCancel(ctx)
+	r := &replication{
+		copier: rep,
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	rwg := &sync.WaitGroup{}
+	rwg.Add(1)
+	go rep.Run(rctx, rwg)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		rwg.Wait()
+		close(r.done)
+	}()
+
+	repmu.Lock()
+	replications[topicname] = r
+	repmu.Unlock()
+}
+
+// stop stops the copier and waits for it to finish draining
+func (r *replication) stop() {
+	r.cancel()
+	<-r.done
+}
+
+// runningCopiers are the copiers currently replicating, sorted by topic
+func runningCopiers() []*replicator.Copier {
+	repmu.Lock()
+	defer repmu.Unlock()
+
+	names := []string{}
+	for name := range replications {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	copiers := []*replicator.Copier{}
+	for _, name := range names {
+		copiers = append(copiers, replications[name].copier)
+	}
+
+	return copiers
+}
+
+// reload reads the configuration file again and applies changes to the running topics,
+// topics are only restarted when their changes cannot be applied while running
+func reload(wg *sync.WaitGroup) {
+	// keeps wg from reaching zero while a topic is restarted
+	wg.Add(1)
+	defer wg.Done()
+
+	logrus.Infof("Reloading configuration file %s", cfile)
+
+	err := config.Load(cfile)
+	if err != nil {
+		logrus.Errorf("Could not reload configuration, continuing with the current configuration: %s", err)
+		return
+	}
+
+	configureLogLevel()
+
+	topics := []string{topic}
+	if all {
+		topics = config.TopicNames()
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range topics {
+		wanted[name] = true
+	}
+
+	repmu.Lock()
+	current := make(map[string]*replication)
+	for name, r := range replications {
+		current[name] = r
+	}
+	repmu.Unlock()
+
+	for name, r := range current {
+		if wanted[name] {
+			continue
+		}
+
+		logrus.Infof("Stopping replication of topic %s that was removed from the configuration", name)
+		r.stop()
+		forget(name)
+	}
+
+	for _, name := range topics {
+		topicconf, err := config.Topic(name)
+		if err != nil {
+			logrus.Errorf("Could not find a configuration for topic %s, continuing with the current configuration", name)
+			continue
+		}
+
+		r, ok := current[name]
+		if !ok {
+			logrus.Infof("Starting replication of topic %s", name)
+			startReplicator(ctx, wg, topicconf, name)
+			continue
+		}
+
+		restart, err := r.copier.Reload(topicconf)
+		if err != nil {
+			logrus.Errorf("Could not apply configuration changes to topic %s, continuing with the current configuration: %s", name, err)
+			continue
+		}
+
+		if restart {
+			r.stop()
+			forget(name)
+			startReplicator(ctx, wg, topicconf, name)
+		}
+	}
+
+	logrus.Infof("Reloaded configuration file %s", cfile)
+}
+
+func forget(name string) {
+	repmu.Lock()
+	delete(replications, name)
+	repmu.Unlock()
+}
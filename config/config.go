@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
+	"sync"
 
 	"github.com/choria-io/go-choria/providers/security"
 	"github.com/ghodss/yaml"
@@ -17,6 +19,10 @@ type replications struct {
 	Logfile  string   `json:"logfile"`
 	TLS      *TLSConf `json:"tls"`
 	StateDir string   `json:"state_dir"`
+	Monitor  int      `json:"monitor"`
+
+	LatencyBuckets []float64   `json:"latency_buckets"`
+	Health         *HealthConf `json:"health"`
 
 	SecurityProvider security.Provider
 }
@@ -25,14 +31,57 @@ type replications struct {
 type AdvisoryConf struct {
 	Target  string `json:"target"`
 	Cluster string `json:"cluster" validate:"enum=source,target"`
-	Age     string `json:"age"`
+	Age     string `json:"age" validate:"duration"`
+}
+
+// AuthConf configures how to authenticate to a NATS server, only one method can be used
+type AuthConf struct {
+	User        string `json:"user"`
+	Password    string `json:"password"`
+	Token       string `json:"token"`
+	NKey        string `json:"nkey"`
+	Credentials string `json:"credentials"`
+}
+
+// DeadLetterConf configures where messages that repeatedly fail to copy are sent
+type DeadLetterConf struct {
+	Subject  string `json:"subject"`
+	Cluster  string `json:"cluster" validate:"enum=source,target"`
+	Attempts int    `json:"attempts"`
+}
+
+// EncryptionConf configures encryption of message data between clusters
+type EncryptionConf struct {
+	Mode        string `json:"mode" validate:"enum=encrypt,decrypt"`
+	KeyFile     string `json:"key_file"`
+	Certificate string `json:"certificate"`
 }
 
-var config = replications{
-	Topics: make(map[string]*TopicConf),
+// HealthConf configures when the health check reports a replicator as unhealthy
+type HealthConf struct {
+	Disconnected   string `json:"disconnected" validate:"duration"`
+	Stalled        string `json:"stalled" validate:"duration"`
+	AdvisorFailing string `json:"advisor_failing" validate:"duration"`
 }
 
-// Load reads configuration from a YAML file
+// RateLimitConf configures how fast messages are copied, limits are per second
+// and a zero limit is unlimited
+type RateLimitConf struct {
+	Messages     float64 `json:"messages"`
+	Bytes        float64 `json:"bytes"`
+	MessageBurst int     `json:"message_burst"`
+	ByteBurst    int     `json:"byte_burst"`
+}
+
+var (
+	config = replications{
+		Topics: make(map[string]*TopicConf),
+	}
+
+	mu sync.Mutex
+)
+
+// Load reads configuration from a YAML file, replacing any previously loaded configuration
 func Load(file string) error {
 	if _, err := os.Stat(file); os.IsNotExist(err) {
 		return fmt.Errorf("file %s not found", file)
@@ -48,24 +97,38 @@ func Load(file string) error {
 		return fmt.Errorf("file %s could not be parsed: %s", file, err)
 	}
 
-	err = json.Unmarshal(j, &config)
+	j, err = interpolate(j)
+	if err != nil {
+		return fmt.Errorf("file %s could not be interpolated: %s", file, err)
+	}
+
+	cfg := replications{
+		Topics: make(map[string]*TopicConf),
+	}
+
+	err = json.Unmarshal(j, &cfg)
 	if err != nil {
 		return fmt.Errorf("could not parse config file %s as YAML: %s", file, err)
 	}
 
-	if config.TLS != nil {
-		config.SecurityProvider, err = config.TLS.SecurityProvider()
+	err = cfg.validate()
+	if err != nil {
+		return err
+	}
+
+	if cfg.TLS != nil {
+		cfg.SecurityProvider, err = cfg.TLS.SecurityProvider()
 		if err != nil {
 			return fmt.Errorf("could not configure system SSL: %s", err)
 		}
 
 	}
 
-	for _, t := range config.Topics {
-		t.SecurityProvider = config.SecurityProvider
+	for _, t := range cfg.Topics {
+		t.SecurityProvider = cfg.SecurityProvider
 
 		if t.TLSc == nil {
-			t.TLSc = config.TLS
+			t.TLSc = cfg.TLS
 		}
 
 		if t.TLSc != nil {
@@ -74,38 +137,117 @@ func Load(file string) error {
 				return fmt.Errorf("could not configure topic %s SSL: %s", t.Name, err)
 			}
 		}
+
+		if t.SourceTLSc != nil {
+			t.SourceSecurityProvider, err = t.SourceTLSc.SecurityProvider()
+			if err != nil {
+				return fmt.Errorf("could not configure topic %s source SSL: %s", t.Name, err)
+			}
+		}
+
+		if t.TargetTLSc != nil {
+			t.TargetSecurityProvider, err = t.TargetTLSc.SecurityProvider()
+			if err != nil {
+				return fmt.Errorf("could not configure topic %s target SSL: %s", t.Name, err)
+			}
+		}
 	}
 
+	mu.Lock()
+	config = cfg
+	mu.Unlock()
+
 	return nil
 }
 
 // StateDirectory is where a cache of seen data will be saved when configured
 func StateDirectory() string {
+	mu.Lock()
+	defer mu.Unlock()
+
 	return config.StateDir
 }
 
 // TLS determines if TLS is configured
 func TLS() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
 	return config.TLS != nil
 }
 
 // Debug enables debug logging
 func Debug() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
 	return config.Debug
 }
 
 // Verbose enables verbose logging
 func Verbose() bool {
+	mu.Lock()
+	defer mu.Unlock()
+
 	return config.Verbose
 }
 
 // LogFile is the file to log to, STDOUT when empty
 func LogFile() string {
+	mu.Lock()
+	defer mu.Unlock()
+
 	return config.Logfile
 }
 
+// MonitorPort is the port to listen on for metrics when replicating all topics
+func MonitorPort() int {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return config.Monitor
+}
+
+// LatencyBuckets are the buckets in seconds for the replication latency histogram, nil for the defaults
+func LatencyBuckets() []float64 {
+	mu.Lock()
+	defer mu.Unlock()
+
+	return config.LatencyBuckets
+}
+
+// Health is the health check configuration, empty when not configured
+func Health() *HealthConf {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if config.Health == nil {
+		return &HealthConf{}
+	}
+
+	return config.Health
+}
+
+// TopicNames is the sorted list of names of all configured topics
+func TopicNames() []string {
+	mu.Lock()
+	defer mu.Unlock()
+
+	names := []string{}
+	for name := range config.Topics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
 // Topic is the configuration for a specific topic from the file
 func Topic(name string) (*TopicConf, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
 	t, ok := config.Topics[name]
 	if !ok {
 		return nil, fmt.Errorf("unknown topic configuration: %s", name)
@@ -0,0 +1,173 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// matches ${reference}
+var referenceRe = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+// interpolate replaces references in the values of the JSON document j, ${NAME} is
+// replaced by the environment variable NAME and ${file:/path} by the contents of the
+// file without trailing new lines. Values of number and boolean settings are converted
+// to the type of the setting once their references are replaced
+func interpolate(j []byte) ([]byte, error) {
+	var doc interface{}
+
+	dec := json.NewDecoder(bytes.NewReader(j))
+	dec.UseNumber()
+
+	err := dec.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	problems := []string{}
+	doc = interpolateValue(doc, reflect.TypeOf(replications{}), &problems)
+
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("%s", strings.Join(problems, ", "))
+	}
+
+	return json.Marshal(doc)
+}
+
+// interpolateValue interpolates v that will be unmarshalled into a value of type t,
+// t is nil for values that are not part of the configuration
+func interpolateValue(v interface{}, t reflect.Type, problems *[]string) interface{} {
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			val[k] = interpolateValue(item, fieldType(t, k), problems)
+		}
+
+	case []interface{}:
+		var elem reflect.Type
+		if t != nil && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) {
+			elem = t.Elem()
+		}
+
+		for i, item := range val {
+			val[i] = interpolateValue(item, elem, problems)
+		}
+
+	case string:
+		return interpolateScalar(val, t, problems)
+	}
+
+	return v
+}
+
+// fieldType is the type of the value stored under key in a value of type t, fields
+// are matched like encoding/json does by their json name or their case insensitive name
+func fieldType(t reflect.Type, key string) reflect.Type {
+	if t == nil {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Map:
+		return t.Elem()
+
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+
+			if name == "" {
+				name = field.Name
+			}
+
+			if strings.EqualFold(name, key) {
+				return field.Type
+			}
+		}
+	}
+
+	return nil
+}
+
+// interpolateScalar interpolates s and converts it to a number or boolean when t is one
+func interpolateScalar(s string, t reflect.Type, problems *[]string) interface{} {
+	if !referenceRe.MatchString(s) {
+		return s
+	}
+
+	out := interpolateString(s, problems)
+
+	if t == nil {
+		return out
+	}
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		_, err := strconv.ParseFloat(out, 64)
+		if err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s is not a number", s))
+			return out
+		}
+
+		return json.Number(out)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(out)
+		if err != nil {
+			*problems = append(*problems, fmt.Sprintf("%s is not a boolean", s))
+			return out
+		}
+
+		return b
+	}
+
+	return out
+}
+
+func interpolateString(s string, problems *[]string) string {
+	return referenceRe.ReplaceAllStringFunc(s, func(match string) string {
+		ref := strings.TrimSpace(match[2 : len(match)-1])
+
+		switch {
+		case strings.HasPrefix(ref, "file:"):
+			file := strings.TrimPrefix(ref, "file:")
+
+			c, err := ioutil.ReadFile(file)
+			if err != nil {
+				*problems = append(*problems, fmt.Sprintf("could not read %s: %s", match, err))
+				return match
+			}
+
+			return strings.TrimRight(string(c), "\r\n")
+
+		case ref == "":
+			*problems = append(*problems, fmt.Sprintf("invalid reference %s", match))
+			return match
+
+		default:
+			value, ok := os.LookupEnv(ref)
+			if !ok {
+				*problems = append(*problems, fmt.Sprintf("environment variable %s is not set", ref))
+				return match
+			}
+
+			return value
+		}
+	})
+}
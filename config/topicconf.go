@@ -1,33 +1,240 @@
 package config
 
 import (
+	"net/url"
+	"reflect"
+	"strings"
+
 	security "github.com/choria-io/go-choria/providers/security"
 )
 
+const (
+	// StreamingType is a NATS Streaming Server, the default
+	StreamingType = "stan"
+
+	// JetStreamType is a NATS Server with JetStream enabled
+	JetStreamType = "jetstream"
+)
+
+const (
+	// AllTargetsPolicy requires a message to be copied to all targets before it is acknowledged
+	AllTargetsPolicy = "all"
+
+	// BestEffortPolicy acknowledges a message once it was copied to any target
+	BestEffortPolicy = "best_effort"
+)
+
+// TargetConf is one of many clusters a topic is replicated to
+type TargetConf struct {
+	Name string    `json:"name"`
+	URL  string    `json:"url"`
+	ID   string    `json:"cluster_id"`
+	Type string    `json:"type" validate:"enum=stan,jetstream"`
+	Auth *AuthConf `json:"auth"`
+}
+
+// JetStream determines if the target is a JetStream server
+func (t *TargetConf) JetStream() bool {
+	return t.Type == JetStreamType
+}
+
 // TopicConf is the configuration for a specific topic
 type TopicConf struct {
-	Topic            string        `json:"topic"`
-	SourceURL        string        `json:"source_url"`
-	SourceID         string        `json:"source_cluster_id"`
-	TargetURL        string        `json:"target_url"`
-	TargetID         string        `json:"target_cluster_id"`
-	Workers          int           `json:"workers"`
-	Queued           bool          `json:"queued"`
-	QueueGroup       string        `json:"queue_group"`
-	Inspect          string        `json:"inspect"`
-	UpdateFlag       string        `json:"update_flag"`
-	MinAge           string        `json:"age"`
-	Name             string        `json:"name"`
-	MonitorPort      int           `json:"monitor"`
-	Advisory         *AdvisoryConf `json:"advisory"`
-	TLSc             *TLSConf      `json:"tls"`
-	DisableTargetTLS bool          `json:"disable_target_tls"`
-	DisableSourceTLS bool          `json:"disable_source_tls"`
-
-	SecurityProvider security.Provider `json:"-"`
+	Topic            string          `json:"topic"`
+	SourceURL        string          `json:"source_url"`
+	SourceID         string          `json:"source_cluster_id"`
+	SourceType       string          `json:"source_type" validate:"enum=stan,jetstream"`
+	SourceStream     string          `json:"source_stream"`
+	SourcePull       bool            `json:"source_pull"`
+	SourceMonitor    string          `json:"source_monitor_url"`
+	SourceAuth       *AuthConf       `json:"source_auth"`
+	LagInterval      string          `json:"lag_interval" validate:"duration"`
+	TargetURL        string          `json:"target_url"`
+	TargetID         string          `json:"target_cluster_id"`
+	TargetType       string          `json:"target_type" validate:"enum=stan,jetstream"`
+	TargetSubject    string          `json:"target_subject"`
+	TargetAuth       *AuthConf       `json:"target_auth"`
+	Targets          []*TargetConf   `json:"targets"`
+	TargetPolicy     string          `json:"target_policy" validate:"enum=all,best_effort"`
+	Bidirectional    bool            `json:"bidirectional"`
+	Envelope         string          `json:"envelope" validate:"enum=json,raw"`
+	Compress         string          `json:"compress" validate:"enum=gzip,zstd,s2"`
+	Decompress       bool            `json:"decompress"`
+	Encryption       *EncryptionConf `json:"encryption"`
+	Workers          int             `json:"workers"`
+	Queued           bool            `json:"queued"`
+	Partitioned      bool            `json:"partitioned"`
+	PartitionKey     string          `json:"partition_key"`
+	QueueGroup       string          `json:"queue_group"`
+	Inspect          string          `json:"inspect"`
+	UpdateFlag       string          `json:"update_flag"`
+	Filter           string          `json:"filter"`
+	StartSequence    uint64          `json:"start_at_sequence"`
+	StartTime        string          `json:"start_at_time"`
+	StartDelta       string          `json:"start_at_time_delta" validate:"duration"`
+	StartLast        bool            `json:"start_with_last_received"`
+	StartNew         bool            `json:"start_new_only"`
+	MaxInflight      int             `json:"max_inflight"`
+	AckWait          string          `json:"ack_wait" validate:"duration"`
+	Deduplicate      bool            `json:"deduplicate"`
+	PublishAsync     bool            `json:"publish_async"`
+	MaxPending       int             `json:"max_pending"`
+	DrainTimeout     string          `json:"drain_timeout" validate:"duration"`
+	RateLimit        *RateLimitConf  `json:"rate_limit"`
+	MinAge           string          `json:"age" validate:"duration"`
+	Name             string          `json:"name"`
+	MonitorPort      int             `json:"monitor"`
+	Advisory         *AdvisoryConf   `json:"advisory"`
+	DeadLetter       *DeadLetterConf `json:"dead_letter"`
+	Auth             *AuthConf       `json:"auth"`
+	TLSc             *TLSConf        `json:"tls"`
+	SourceTLSc       *TLSConf        `json:"source_tls"`
+	TargetTLSc       *TLSConf        `json:"target_tls"`
+	DisableTargetTLS bool            `json:"disable_target_tls"`
+	DisableSourceTLS bool            `json:"disable_source_tls"`
+
+	SecurityProvider       security.Provider `json:"-"`
+	SourceSecurityProvider security.Provider `json:"-"`
+	TargetSecurityProvider security.Provider `json:"-"`
 }
 
 // TLS determines if the topic has a TLS configuration set
 func (t *TopicConf) TLS() bool {
 	return t.TLSc != nil
 }
+
+// SourceSecurity is the security provider for connections to the source, from source_tls or else tls
+func (t *TopicConf) SourceSecurity() security.Provider {
+	if t.SourceSecurityProvider != nil {
+		return t.SourceSecurityProvider
+	}
+
+	return t.SecurityProvider
+}
+
+// TargetSecurity is the security provider for connections to the targets, from target_tls or else tls
+func (t *TopicConf) TargetSecurity() security.Provider {
+	if t.TargetSecurityProvider != nil {
+		return t.TargetSecurityProvider
+	}
+
+	return t.SecurityProvider
+}
+
+// SourceJetStream determines if the source is a JetStream server
+func (t *TopicConf) SourceJetStream() bool {
+	return t.SourceType == JetStreamType
+}
+
+// TargetJetStream determines if the target is a JetStream server
+func (t *TopicConf) TargetJetStream() bool {
+	return t.TargetType == JetStreamType
+}
+
+// SourceAuthentication is the authentication used to connect to the source, source_auth or else auth
+func (t *TopicConf) SourceAuthentication() *AuthConf {
+	if t.SourceAuth != nil {
+		return t.SourceAuth
+	}
+
+	return t.Auth
+}
+
+// TargetAuthentication is the authentication used to connect to a target, the auth of
+// the target when replicating to many targets, target_auth or else auth
+func (t *TopicConf) TargetAuthentication(target *TargetConf) *AuthConf {
+	if target != nil && target.Auth != nil {
+		return target.Auth
+	}
+
+	if t.TargetAuth != nil {
+		return t.TargetAuth
+	}
+
+	return t.Auth
+}
+
+// Changes lists the settings that differ between two configurations by their configuration file names
+func (t *TopicConf) Changes(other *TopicConf) []string {
+	changes := []string{}
+
+	a := reflect.ValueOf(t).Elem()
+	b := reflect.ValueOf(other).Elem()
+
+	for i := 0; i < a.NumField(); i++ {
+		name := strings.Split(a.Type().Field(i).Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if !reflect.DeepEqual(a.Field(i).Interface(), b.Field(i).Interface()) {
+			changes = append(changes, name)
+		}
+	}
+
+	return changes
+}
+
+// Redacted is a copy of the configuration with credentials removed, suitable for display
+func (t *TopicConf) Redacted() *TopicConf {
+	r := *t
+
+	r.SourceURL = redactURL(t.SourceURL)
+	r.TargetURL = redactURL(t.TargetURL)
+
+	r.Auth = t.Auth.redacted()
+	r.SourceAuth = t.SourceAuth.redacted()
+	r.TargetAuth = t.TargetAuth.redacted()
+
+	r.Targets = make([]*TargetConf, len(t.Targets))
+	for i, tc := range t.Targets {
+		target := *tc
+		target.URL = redactURL(tc.URL)
+		target.Auth = tc.Auth.redacted()
+		r.Targets[i] = &target
+	}
+
+	return &r
+}
+
+// redacted is a copy of the authentication settings with passwords and tokens removed
+func (a *AuthConf) redacted() *AuthConf {
+	if a == nil {
+		return nil
+	}
+
+	r := *a
+	if r.Password != "" {
+		r.Password = "xxxxx"
+	}
+
+	if r.Token != "" {
+		r.Token = "xxxxx"
+	}
+
+	return &r
+}
+
+// redactURL removes passwords from a comma separated list of server urls, a user
+// without a password is a token and is removed too
+func redactURL(servers string) string {
+	if servers == "" {
+		return servers
+	}
+
+	parts := strings.Split(servers, ",")
+	for i, s := range parts {
+		u, err := url.Parse(strings.TrimSpace(s))
+		if err != nil || u.User == nil {
+			continue
+		}
+
+		if _, ok := u.User.Password(); !ok {
+			u.User = url.User("xxxxx")
+		}
+
+		parts[i] = u.Redacted()
+	}
+
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,296 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/choria-io/go-choria/validator"
+)
+
+// ValidationError lists all the problems found in a configuration
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(e.Problems, "; "))
+}
+
+// validate checks the configuration of every topic and reports all problems found
+func (r *replications) validate() error {
+	problems := validateTags("", reflect.ValueOf(r).Elem())
+
+	if r.TLS != nil {
+		problems = append(problems, r.TLS.validate("tls")...)
+	}
+
+	names := []string{}
+	for name := range r.Topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, p := range r.Topics[name].validate() {
+			problems = append(problems, fmt.Sprintf("topic %s: %s", name, p))
+		}
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+
+	return nil
+}
+
+// validate checks a topic configuration, settings that are not set are only
+// reported when they have no default
+func (t *TopicConf) validate() []string {
+	problems := validateTags("", reflect.ValueOf(t).Elem())
+
+	if t.Topic == "" {
+		problems = append(problems, "topic is required")
+	}
+
+	if t.SourceID == "" && !t.SourceJetStream() {
+		problems = append(problems, "source_cluster_id is required")
+	}
+
+	if t.SourceURL != "" {
+		problems = append(problems, validateURLs("source_url", t.SourceURL)...)
+	}
+
+	if t.SourceMonitor != "" {
+		u, err := url.Parse(t.SourceMonitor)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, "source_monitor_url should be a http or https url")
+		}
+	}
+
+	if t.TargetURL == "" && len(t.Targets) == 0 {
+		problems = append(problems, "target_url or targets is required")
+	}
+
+	if t.TargetURL != "" {
+		problems = append(problems, validateURLs("target_url", t.TargetURL)...)
+
+		if t.TargetID == "" && !t.TargetJetStream() && len(t.Targets) == 0 {
+			problems = append(problems, "target_cluster_id is required")
+		}
+	}
+
+	for i, target := range t.Targets {
+		name := fmt.Sprintf("targets[%d]", i)
+
+		if target.URL == "" {
+			problems = append(problems, fmt.Sprintf("%s.url is required", name))
+		} else {
+			problems = append(problems, validateURLs(name+".url", target.URL)...)
+		}
+
+		if target.ID == "" && !target.JetStream() {
+			problems = append(problems, fmt.Sprintf("%s.cluster_id is required", name))
+		}
+
+		if target.Auth != nil {
+			problems = append(problems, target.Auth.validate(name+".auth")...)
+		}
+	}
+
+	if t.StartTime != "" {
+		_, err := time.Parse(time.RFC3339, t.StartTime)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("start_at_time: %s", err))
+		}
+	}
+
+	if t.Encryption != nil {
+		if t.Encryption.KeyFile != "" && !fileExists(t.Encryption.KeyFile) {
+			problems = append(problems, fmt.Sprintf("encryption.key_file: %s does not exist", t.Encryption.KeyFile))
+		}
+
+		if t.Encryption.Certificate != "" && !fileExists(t.Encryption.Certificate) {
+			problems = append(problems, fmt.Sprintf("encryption.certificate: %s does not exist", t.Encryption.Certificate))
+		}
+	}
+
+	for _, a := range []struct {
+		name string
+		auth *AuthConf
+	}{{"auth", t.Auth}, {"source_auth", t.SourceAuth}, {"target_auth", t.TargetAuth}} {
+		if a.auth != nil {
+			problems = append(problems, a.auth.validate(a.name)...)
+		}
+	}
+
+	if t.TLSc != nil {
+		problems = append(problems, t.TLSc.validate("tls")...)
+	}
+
+	if t.SourceTLSc != nil {
+		problems = append(problems, t.SourceTLSc.validate("source_tls")...)
+
+		if t.DisableSourceTLS {
+			problems = append(problems, "source_tls cannot be used with disable_source_tls")
+		}
+	}
+
+	if t.TargetTLSc != nil {
+		problems = append(problems, t.TargetTLSc.validate("target_tls")...)
+
+		if t.DisableTargetTLS {
+			problems = append(problems, "target_tls cannot be used with disable_target_tls")
+		}
+	}
+
+	return problems
+}
+
+// validate checks that a single authentication method is used and that its files exist
+func (a *AuthConf) validate(prefix string) []string {
+	problems := []string{}
+
+	methods := []string{}
+	if a.User != "" {
+		methods = append(methods, "user")
+	}
+	if a.Token != "" {
+		methods = append(methods, "token")
+	}
+	if a.NKey != "" {
+		methods = append(methods, "nkey")
+	}
+	if a.Credentials != "" {
+		methods = append(methods, "credentials")
+	}
+
+	if len(methods) > 1 {
+		problems = append(problems, fmt.Sprintf("%s: only one of %s can be set", prefix, strings.Join(methods, ", ")))
+	}
+
+	if a.Password != "" && a.User == "" {
+		problems = append(problems, fmt.Sprintf("%s.user is required with a password", prefix))
+	}
+
+	if a.NKey != "" && !fileExists(a.NKey) {
+		problems = append(problems, fmt.Sprintf("%s.nkey: %s does not exist", prefix, a.NKey))
+	}
+
+	if a.Credentials != "" && !fileExists(a.Credentials) {
+		problems = append(problems, fmt.Sprintf("%s.credentials: %s does not exist", prefix, a.Credentials))
+	}
+
+	return problems
+}
+
+// validate checks the scheme and that the files used by it exist
+func (t *TLSConf) validate(prefix string) []string {
+	problems := []string{}
+
+	switch t.Scheme {
+	case "file", "manual":
+		for _, f := range []struct {
+			name string
+			file string
+		}{{"ca", t.CA}, {"cert", t.Cert}, {"key", t.Key}} {
+			switch {
+			case f.file == "":
+				problems = append(problems, fmt.Sprintf("%s.%s is required", prefix, f.name))
+			case !fileExists(f.file):
+				problems = append(problems, fmt.Sprintf("%s.%s: %s does not exist", prefix, f.name, f.file))
+			}
+		}
+
+	case "puppet":
+		if t.SSLDir != "" && !fileExists(t.SSLDir) {
+			problems = append(problems, fmt.Sprintf("%s.ssl_dir: %s does not exist", prefix, t.SSLDir))
+		}
+
+	default:
+		problems = append(problems, fmt.Sprintf("%s.scheme: unknown security scheme %q", prefix, t.Scheme))
+	}
+
+	return problems
+}
+
+// validateTags checks fields that have a validate tag and a value, descending into
+// nested settings, problems are reported using the configuration file names
+func validateTags(prefix string, val reflect.Value) []string {
+	problems := []string{}
+
+	for i := 0; i < val.NumField(); i++ {
+		field := val.Type().Field(i)
+		value := val.Field(i)
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		name = prefix + name
+
+		switch value.Kind() {
+		case reflect.Ptr:
+			if !value.IsNil() && value.Elem().Kind() == reflect.Struct {
+				problems = append(problems, validateTags(name+".", value.Elem())...)
+			}
+
+		case reflect.Slice:
+			for j := 0; j < value.Len(); j++ {
+				item := value.Index(j)
+				if item.Kind() == reflect.Ptr && !item.IsNil() && item.Elem().Kind() == reflect.Struct {
+					problems = append(problems, validateTags(fmt.Sprintf("%s[%d].", name, j), item.Elem())...)
+				}
+			}
+		}
+
+		if field.Tag.Get("validate") == "" || value.IsZero() {
+			continue
+		}
+
+		_, err := validator.ValidateStructField(val.Addr().Interface(), field.Name)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %s", name, err))
+		}
+	}
+
+	return problems
+}
+
+// validateURLs checks a comma separated list of server urls, urls without a scheme are nats urls
+func validateURLs(name string, servers string) []string {
+	problems := []string{}
+
+	for _, s := range strings.Split(servers, ",") {
+		s = strings.TrimSpace(s)
+		if !strings.Contains(s, "://") {
+			s = "nats://" + s
+		}
+
+		u, err := url.Parse(s)
+		if err != nil {
+			if uerr, ok := err.(*url.Error); ok {
+				err = uerr.Err
+			}
+
+			problems = append(problems, fmt.Sprintf("%s: invalid url: %s", name, err))
+			continue
+		}
+
+		if u.Hostname() == "" {
+			problems = append(problems, fmt.Sprintf("%s: %s has no host", name, u.Redacted()))
+		}
+	}
+
+	return problems
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+
+	return err == nil
+}
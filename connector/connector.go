@@ -2,9 +2,12 @@ package connector
 
 import (
 	"context"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/choria-io/go-choria/providers/security"
 	"github.com/choria-io/stream-replicator/backoff"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/nats-io/nats.go"
@@ -14,6 +17,10 @@ import (
 
 // Connection holds a connection to NATS Streaming
 type Connection struct {
+	// down is when the connection was created or stopped being connected in unix
+	// nanoseconds, 0 while connected, accessed atomically
+	down int64
+
 	url  string
 	log  *logrus.Entry
 	conn stan.Conn
@@ -21,8 +28,45 @@ type Connection struct {
 	cfg  *config.TopicConf
 	id   string
 	tls  bool
+	auth *config.AuthConf
+	sec  security.Provider
 	subs []*subscription
 	mu   *sync.Mutex
+
+	// state is the connection state, it is kept outside of mu so it can be
+	// read while connecting
+	state atomic.Value
+}
+
+const (
+	// StateDisconnected is a connection that is not connected
+	StateDisconnected = "disconnected"
+
+	// StateConnecting is a connection making its initial connection
+	StateConnecting = "connecting"
+
+	// StateConnected is a connection that is connected
+	StateConnected = "connected"
+
+	// StateReconnecting is a connection that is reconnecting after being disconnected
+	StateReconnecting = "reconnecting"
+
+	// StateClosed is a connection that was closed
+	StateClosed = "closed"
+)
+
+// Stream is a connection to either NATS Streaming or JetStream
+type Stream interface {
+	Connect(ctx context.Context)
+	Subscribe(subject string, qgroup string, cb MsgHandler, opts SubscribeOptions) error
+	Publish(subject string, data []byte) error
+	PublishWithID(subject string, id string, data []byte) error
+	PublishAsync(subject string, data []byte, cb func(err error)) error
+	NatsConn() *nats.Conn
+	State() string
+	Disconnected() time.Duration
+	CloseSubscriptions() error
+	Close() error
 }
 
 // Direction indicates which of the connectors to connect to
@@ -36,23 +80,52 @@ const Target = Direction(1)
 
 // New creates a new connector
 func New(name string, tls bool, dir Direction, cfg *config.TopicConf, logger *logrus.Entry) *Connection {
-	c := Connection{
-		url:  cfg.TargetURL,
+	if dir == Source {
+		return newConnection(name, tls || cfg.SourceTLSc != nil, cfg.SourceURL, cfg.SourceID, cfg.SourceAuthentication(), cfg.SourceSecurity(), cfg, logger)
+	}
+
+	return newConnection(name, tls || cfg.TargetTLSc != nil, cfg.TargetURL, cfg.TargetID, cfg.TargetAuthentication(nil), cfg.TargetSecurity(), cfg, logger)
+}
+
+func newConnection(name string, tls bool, url string, id string, auth *config.AuthConf, sec security.Provider, cfg *config.TopicConf, logger *logrus.Entry) *Connection {
+	return &Connection{
+		url:  url,
 		log:  logger,
 		name: name,
-		id:   cfg.TargetID,
+		id:   id,
 		tls:  tls,
+		auth: auth,
+		sec:  sec,
 		cfg:  cfg,
 		subs: []*subscription{},
 		mu:   &sync.Mutex{},
+		down: time.Now().UnixNano(),
 	}
+}
 
+// NewStream creates a connector for the kind of stream configured for the given direction
+func NewStream(name string, tls bool, dir Direction, cfg *config.TopicConf, logger *logrus.Entry) Stream {
+	jetstream := cfg.TargetJetStream()
 	if dir == Source {
-		c.url = cfg.SourceURL
-		c.id = cfg.SourceID
+		jetstream = cfg.SourceJetStream()
 	}
 
-	return &c
+	if jetstream {
+		return NewJetStream(name, tls, dir, cfg, logger)
+	}
+
+	return New(name, tls, dir, cfg, logger)
+}
+
+// NewTarget creates a connector for one of many targets of a topic
+func NewTarget(name string, tls bool, target *config.TargetConf, cfg *config.TopicConf, logger *logrus.Entry) Stream {
+	c := newConnection(name, tls || cfg.TargetTLSc != nil, target.URL, target.ID, cfg.TargetAuthentication(target), cfg.TargetSecurity(), cfg, logger.WithField("target", target.Name))
+
+	if target.JetStream() {
+		return &JetStream{Connection: c}
+	}
+
+	return c
 }
 
 // NatsConn returns the active nats connection
@@ -60,16 +133,47 @@ func (c *Connection) NatsConn() *nats.Conn {
 	return c.conn.NatsConn()
 }
 
+// State is the current state of the connection
+func (c *Connection) State() string {
+	state, ok := c.state.Load().(string)
+	if !ok {
+		return StateDisconnected
+	}
+
+	return state
+}
+
+// Disconnected is how long the connection has not been connected, 0 when connected
+func (c *Connection) Disconnected() time.Duration {
+	down := atomic.LoadInt64(&c.down)
+	if down == 0 {
+		return 0
+	}
+
+	return time.Since(time.Unix(0, down))
+}
+
+func (c *Connection) setState(state string) {
+	c.state.Store(state)
+
+	if state == StateConnected {
+		atomic.StoreInt64(&c.down, 0)
+	} else {
+		atomic.CompareAndSwapInt64(&c.down, 0, time.Now().UnixNano())
+	}
+}
+
 // Connect connects to the configured stream
 func (c *Connection) Connect(ctx context.Context) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.setState(StateConnecting)
 	c.connectSTAN(ctx)
 }
 
 // Subscribe subscribes to a subject, if group is empty a normal subscription is done
-func (c *Connection) Subscribe(subject string, qgroup string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) (err error) {
+func (c *Connection) Subscribe(subject string, qgroup string, cb MsgHandler, opts SubscribeOptions) (err error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -89,12 +193,37 @@ func (c *Connection) Subscribe(subject string, qgroup string, cb stan.MsgHandler
 	return
 }
 
+// CloseSubscriptions stops all subscriptions without removing the durable
+// subscriptions so they can be resumed later
+func (c *Connection) CloseSubscriptions() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+
+	for _, sub := range c.subs {
+		if sub.sub == nil {
+			continue
+		}
+
+		cerr := sub.sub.Close()
+		if cerr != nil {
+			err = cerr
+		}
+	}
+
+	c.subs = []*subscription{}
+
+	return err
+}
+
 // Close closes the connection and forgets all subscriptions
 func (c *Connection) Close() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
 	c.subs = []*subscription{}
+	c.setState(StateClosed)
 
 	return c.conn.Close()
 }
@@ -107,6 +236,26 @@ func (c *Connection) Publish(subject string, data []byte) error {
 	return c.conn.Publish(subject, data)
 }
 
+// PublishWithID publishes data to a specific subject, NATS Streaming does not support
+// de-duplicating messages so the id is ignored
+func (c *Connection) PublishWithID(subject string, id string, data []byte) error {
+	return c.Publish(subject, data)
+}
+
+// PublishAsync publishes data to a specific subject without waiting for the
+// server to acknowledge it, cb is called in a new go routine once the ack or
+// an error is received so it is safe to publish from within cb
+func (c *Connection) PublishAsync(subject string, data []byte, cb func(err error)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, err := c.conn.PublishAsync(subject, data, func(_ string, err error) {
+		go cb(err)
+	})
+
+	return err
+}
+
 func (c *Connection) reconnect(ctx context.Context, reason error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -115,6 +264,7 @@ func (c *Connection) reconnect(ctx context.Context, reason error) {
 
 	c.log.Errorf("Reconnecting to NATS Stream after disconnection: %s", reason)
 
+	c.setState(StateReconnecting)
 	c.connectSTAN(ctx)
 
 	c.log.Infof("Resubscribing to %d subscriptions", len(c.subs))
@@ -130,6 +280,7 @@ func (c *Connection) connectSTAN(ctx context.Context) {
 	n := c.connectNATS(ctx)
 	if n == nil {
 		c.log.Errorf("%s NATS connection could not be established, cannot connect to the Stream", c.name)
+		c.setState(StateDisconnected)
 		return
 	}
 
@@ -150,12 +301,14 @@ func (c *Connection) connectSTAN(ctx context.Context) {
 
 			if ctx.Err() != nil {
 				c.log.Errorf("%s initial connection canceled due to shut down", c.name)
+				c.setState(StateDisconnected)
 				return
 			}
 
 			c.log.Infof("%s NATS Stream client failed connection attempt %d", c.name, try)
 
 			if backoff.FiveSec.InterruptableSleep(ctx, try) != nil {
+				c.setState(StateDisconnected)
 				return
 			}
 
@@ -164,6 +317,8 @@ func (c *Connection) connectSTAN(ctx context.Context) {
 
 		break
 	}
+
+	c.setState(StateConnected)
 }
 
 func (c *Connection) connectNATS(ctx context.Context) (natsc *nats.Conn) {
@@ -178,7 +333,12 @@ func (c *Connection) connectNATS(ctx context.Context) (natsc *nats.Conn) {
 
 	if c.tls {
 		c.log.Debugf("Configuring TLS on NATS connection to %s", c.url)
-		tlsc, err := c.cfg.SecurityProvider.TLSConfig()
+		if c.sec == nil {
+			c.log.Errorf("Failed to configure TLS: no security provider configured")
+			return nil
+		}
+
+		tlsc, err := c.sec.TLSConfig()
 		if err != nil {
 			c.log.Errorf("Failed to configure TLS: %s", err)
 			return nil
@@ -187,7 +347,14 @@ func (c *Connection) connectNATS(ctx context.Context) (natsc *nats.Conn) {
 		options = append(options, nats.Secure(tlsc))
 	}
 
-	var err error
+	auth, err := authOptions(c.auth)
+	if err != nil {
+		c.log.Errorf("Failed to configure authentication: %s", err)
+		return nil
+	}
+
+	options = append(options, auth...)
+
 	try := 0
 
 	for {
@@ -224,6 +391,34 @@ func (c *Connection) connectNATS(ctx context.Context) (natsc *nats.Conn) {
 	return
 }
 
+// authOptions are the NATS options that authenticate using the configured method
+func authOptions(auth *config.AuthConf) ([]nats.Option, error) {
+	if auth == nil {
+		return nil, nil
+	}
+
+	switch {
+	case auth.Credentials != "":
+		return []nats.Option{nats.UserCredentials(auth.Credentials)}, nil
+
+	case auth.NKey != "":
+		opt, err := nats.NkeyOptionFromSeed(auth.NKey)
+		if err != nil {
+			return nil, fmt.Errorf("could not load nkey seed %s: %s", auth.NKey, err)
+		}
+
+		return []nats.Option{opt}, nil
+
+	case auth.Token != "":
+		return []nats.Option{nats.Token(auth.Token)}, nil
+
+	case auth.User != "":
+		return []nats.Option{nats.UserInfo(auth.User, auth.Password)}, nil
+	}
+
+	return nil, nil
+}
+
 func (c *Connection) disconCb(nc *nats.Conn) {
 	err := nc.LastError()
 
@@ -232,11 +427,19 @@ func (c *Connection) disconCb(nc *nats.Conn) {
 	} else {
 		c.log.Warnf("%s NATS client connection got disconnected", nc.Opts.Name)
 	}
+
+	if c.State() == StateConnected {
+		c.setState(StateReconnecting)
+	}
 }
 
 func (c *Connection) reconCb(nc *nats.Conn) {
 	c.log.Warnf("%s NATS client reconnected after a previous disconnection, connected to %s", nc.Opts.Name, nc.ConnectedUrl())
 	reconnectCtr.WithLabelValues(c.name, c.cfg.Name).Inc()
+
+	if c.State() == StateReconnecting {
+		c.setState(StateConnected)
+	}
 }
 
 func (c *Connection) closedCb(nc *nats.Conn) {
@@ -2,12 +2,16 @@ package connector
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/choria-io/stream-replicator/config"
 	conntest "github.com/choria-io/stream-replicator/connector/test"
+	gnatsd "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
 	"github.com/sirupsen/logrus"
@@ -53,6 +57,99 @@ var _ = Describe("Connector", func() {
 		})
 	})
 
+	Describe("TLS", func() {
+		It("Should use the security provider of each side", func() {
+			def, err := (&config.TLSConf{Scheme: "puppet", Identity: "default.example.net"}).SecurityProvider()
+			Expect(err).ToNot(HaveOccurred())
+			tgt, err := (&config.TLSConf{Scheme: "puppet", Identity: "target.example.net"}).SecurityProvider()
+			Expect(err).ToNot(HaveOccurred())
+
+			conf.SecurityProvider = def
+			conf.TargetTLSc = &config.TLSConf{Scheme: "puppet", Identity: "target.example.net"}
+			conf.TargetSecurityProvider = tgt
+
+			c := New("testcon", false, Source, conf, log)
+			Expect(c.tls).To(BeFalse())
+			Expect(c.sec).To(BeIdenticalTo(def))
+
+			c = New("testcon", false, Target, conf, log)
+			Expect(c.tls).To(BeTrue())
+			Expect(c.sec).To(BeIdenticalTo(tgt))
+
+			target := &config.TargetConf{URL: "nats://localhost:44222", ID: "right"}
+			c = NewTarget("testcon", false, target, conf, log).(*Connection)
+			Expect(c.tls).To(BeTrue())
+			Expect(c.sec).To(BeIdenticalTo(tgt))
+		})
+	})
+
+	Describe("Authentication", func() {
+		It("Should select the authentication for each side", func() {
+			conf.Auth = &config.AuthConf{Token: "shared"}
+			conf.SourceAuth = &config.AuthConf{User: "src", Password: "secret"}
+
+			Expect(New("testcon", false, Source, conf, log).auth).To(Equal(conf.SourceAuth))
+			Expect(New("testcon", false, Target, conf, log).auth).To(Equal(conf.Auth))
+
+			target := &config.TargetConf{URL: "nats://localhost:44222", ID: "right", Auth: &config.AuthConf{Token: "target"}}
+			Expect(NewTarget("testcon", false, target, conf, log).(*Connection).auth).To(Equal(target.Auth))
+
+			target.Auth = nil
+			conf.TargetAuth = &config.AuthConf{Credentials: "/etc/right.creds"}
+			Expect(NewTarget("testcon", false, target, conf, log).(*Connection).auth).To(Equal(conf.TargetAuth))
+		})
+
+		It("Should create options for the configured method", func() {
+			opts, err := authOptions(nil)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(opts).To(BeEmpty())
+
+			for _, auth := range []*config.AuthConf{
+				{User: "u", Password: "p"},
+				{Token: "t"},
+				{Credentials: "/etc/sr.creds"},
+			} {
+				opts, err = authOptions(auth)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(opts).To(HaveLen(1))
+			}
+
+			_, err = authOptions(&config.AuthConf{NKey: "/nonexisting"})
+			Expect(err).To(MatchError(HavePrefix("could not load nkey seed /nonexisting: ")))
+		})
+
+		It("Should authenticate to the server", func() {
+			ns, err := gnatsd.NewServer(&gnatsd.Options{
+				Host:          "localhost",
+				Port:          34223,
+				NoSigs:        true,
+				Authorization: "s3cret",
+			})
+			Expect(err).ToNot(HaveOccurred())
+			go ns.Start()
+			defer ns.Shutdown()
+
+			if !ns.ReadyForConnections(10 * time.Second) {
+				panic("NATS server did not become ready after 10 seconds")
+			}
+
+			conf.SourceURL = "nats://localhost:34223"
+
+			ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+			defer cancel()
+
+			c := New("testcon", false, Source, conf, log)
+			Expect(c.connectNATS(ctx)).To(BeNil())
+
+			conf.SourceAuth = &config.AuthConf{Token: "s3cret"}
+			c = New("testcon", false, Source, conf, log)
+			nc := c.connectNATS(context.Background())
+			Expect(nc).ToNot(BeNil())
+			Expect(nc.IsConnected()).To(BeTrue())
+			nc.Close()
+		})
+	})
+
 	Describe("Connect", func() {
 		It("Should connect to the stream", func() {
 			ctx, cancel := context.WithCancel(context.Background())
@@ -69,8 +166,176 @@ var _ = Describe("Connector", func() {
 			defer left.Shutdown()
 
 			c := New("testcon", false, Source, conf, log)
+			Expect(c.State()).To(Equal(StateDisconnected))
+			Expect(c.Disconnected()).To(BeNumerically(">", 0))
+
+			c.Connect(ctx)
+			Expect(c.State()).To(Equal(StateConnected))
+			Expect(c.Disconnected()).To(BeZero())
+
+			c.Close()
+			Expect(c.State()).To(Equal(StateClosed))
+			Expect(c.Disconnected()).To(BeNumerically(">", 0))
+		})
+	})
+
+	Describe("JetStream", func() {
+		It("Should replicate using a durable consumer", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storeDir, err := ioutil.TempDir("", "")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(storeDir)
+
+			ns := conntest.RunJetStreamServer("localhost", 34223, storeDir)
+			defer ns.Shutdown()
+
+			if !ns.ReadyForConnections(10 * time.Second) {
+				panic("NATS server did not become ready after 10 seconds")
+			}
+
+			conf.SourceURL = "nats://localhost:34223"
+			conf.SourceType = config.JetStreamType
+			conf.SourceStream = "TESTING"
+			conf.TargetURL = "nats://localhost:34223"
+			conf.TargetType = config.JetStreamType
+			conf.Topic = "testing.source"
+
+			c := NewJetStream("testcon", false, Source, conf, log)
 			c.Connect(ctx)
 			defer c.Close()
+
+			_, err = c.js.AddStream(&nats.StreamConfig{Name: "TESTING", Subjects: []string{"testing.>"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			t := NewStream("testcon", false, Target, conf, log)
+			t.Connect(ctx)
+			defer t.Close()
+
+			Expect(t.Publish("testing.source", []byte("hello"))).To(Succeed())
+
+			acked := make(chan error, 1)
+			Expect(t.PublishAsync("testing.source", []byte("world"), func(err error) { acked <- err })).To(Succeed())
+			Eventually(acked, 5*time.Second).Should(Receive(BeNil()))
+
+			msgs := make(chan *Msg, 2)
+			err = c.Subscribe(conf.Topic, "", func(msg *Msg) {
+				msgs <- msg
+				Expect(msg.Ack()).To(Succeed())
+			}, SubscribeOptions{Durable: "testing", MaxInflight: 10})
+			Expect(err).ToNot(HaveOccurred())
+
+			var msg *Msg
+			Eventually(msgs, 5*time.Second).Should(Receive(&msg))
+			Expect(msg.Data).To(Equal([]byte("hello")))
+			Expect(msg.Sequence).To(Equal(uint64(1)))
+			Expect(msg.Redelivered).To(BeFalse())
+
+			Eventually(msgs, 5*time.Second).Should(Receive(&msg))
+			Expect(msg.Data).To(Equal([]byte("world")))
+
+			_, err = c.js.ConsumerInfo("TESTING", "testing")
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(c.CloseSubscriptions()).To(Succeed())
+			Expect(t.Publish("testing.source", []byte("again"))).To(Succeed())
+			Consistently(msgs, 500*time.Millisecond).ShouldNot(Receive())
+
+			_, err = c.js.ConsumerInfo("TESTING", "testing")
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should share a consumer between workers subscribing at the same time", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storeDir, err := ioutil.TempDir("", "")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(storeDir)
+
+			ns := conntest.RunJetStreamServer("localhost", 34223, storeDir)
+			defer ns.Shutdown()
+
+			if !ns.ReadyForConnections(10 * time.Second) {
+				panic("NATS server did not become ready after 10 seconds")
+			}
+
+			conf.SourceURL = "nats://localhost:34223"
+			conf.SourceType = config.JetStreamType
+			conf.SourceStream = "TESTING"
+			conf.Topic = "testing.source"
+
+			workers := make([]*JetStream, 4)
+			for i := range workers {
+				workers[i] = NewJetStream(fmt.Sprintf("testcon_%d", i), false, Source, conf, log)
+				workers[i].Connect(ctx)
+				defer workers[i].Close()
+			}
+
+			_, err = workers[0].js.AddStream(&nats.StreamConfig{Name: "TESTING", Subjects: []string{"testing.>"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			msgs := make(chan *Msg, 10)
+			errs := make(chan error, len(workers))
+			for _, w := range workers {
+				go func(w *JetStream) {
+					errs <- w.Subscribe(conf.Topic, "testing_grp", func(msg *Msg) {
+						msgs <- msg
+						msg.Ack()
+					}, SubscribeOptions{Durable: "testing", MaxInflight: 10})
+				}(w)
+			}
+
+			for range workers {
+				Eventually(errs, 5*time.Second).Should(Receive(BeNil()))
+			}
+
+			nfo, err := workers[0].js.ConsumerInfo("TESTING", "testing")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.Config.DeliverSubject).To(Equal("_INBOX.sr.TESTING.testing"))
+			Expect(nfo.Config.DeliverGroup).To(Equal("testing_grp"))
+
+			Expect(workers[0].Publish("testing.source", []byte("hello"))).To(Succeed())
+
+			var msg *Msg
+			Eventually(msgs, 5*time.Second).Should(Receive(&msg))
+			Expect(msg.Data).To(Equal([]byte("hello")))
+			Consistently(msgs, 500*time.Millisecond).ShouldNot(Receive())
+		})
+
+		It("Should de-duplicate messages published with the same id", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			storeDir, err := ioutil.TempDir("", "")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(storeDir)
+
+			ns := conntest.RunJetStreamServer("localhost", 34223, storeDir)
+			defer ns.Shutdown()
+
+			if !ns.ReadyForConnections(10 * time.Second) {
+				panic("NATS server did not become ready after 10 seconds")
+			}
+
+			conf.TargetURL = "nats://localhost:34223"
+			conf.TargetType = config.JetStreamType
+
+			t := NewJetStream("testcon", false, Target, conf, log)
+			t.Connect(ctx)
+			defer t.Close()
+
+			_, err = t.js.AddStream(&nats.StreamConfig{Name: "TESTING", Subjects: []string{"testing.>"}})
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(t.PublishWithID("testing.target", "dc1-1", []byte("hello"))).To(Succeed())
+			Expect(t.PublishWithID("testing.target", "dc1-1", []byte("hello"))).To(Succeed())
+			Expect(t.PublishWithID("testing.target", "dc1-2", []byte("world"))).To(Succeed())
+
+			nfo, err := t.js.StreamInfo("TESTING")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(nfo.State.Msgs).To(Equal(uint64(2)))
 		})
 	})
 })
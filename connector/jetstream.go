@@ -0,0 +1,332 @@
+package connector
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/backoff"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/nats-io/nats.go"
+	"github.com/sirupsen/logrus"
+)
+
+// JetStream holds a connection to a NATS JetStream enabled server
+//
+// Consumers are created on the configured source stream and bound to
+// rather than being created by the subscription, this ensures that closing
+// a subscription never deletes the durable consumer
+type JetStream struct {
+	*Connection
+
+	ctx  context.Context
+	nc   *nats.Conn
+	js   nats.JetStreamContext
+	subs []*nats.Subscription
+}
+
+// consumerMu serializes looking up and creating consumers, all the workers of a
+// copier subscribe to the same durable consumer at about the same time
+var consumerMu sync.Mutex
+
+// NewJetStream creates a new JetStream connector
+func NewJetStream(name string, tls bool, dir Direction, cfg *config.TopicConf, logger *logrus.Entry) *JetStream {
+	return &JetStream{
+		Connection: New(name, tls, dir, cfg, logger),
+	}
+}
+
+// NatsConn returns the active nats connection
+func (j *JetStream) NatsConn() *nats.Conn {
+	return j.nc
+}
+
+// Connect connects to the NATS server and prepares the JetStream context
+func (j *JetStream) Connect(ctx context.Context) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	j.ctx = ctx
+	j.setState(StateConnecting)
+
+	j.nc = j.connectNATS(ctx)
+	if j.nc == nil {
+		j.log.Errorf("%s NATS connection could not be established, cannot connect to JetStream", j.name)
+		j.setState(StateDisconnected)
+		return
+	}
+
+	var err error
+	j.js, err = j.nc.JetStream()
+	if err != nil {
+		j.log.Errorf("%s could not create JetStream context: %s", j.name, err)
+		j.setState(StateDisconnected)
+		return
+	}
+
+	j.setState(StateConnected)
+}
+
+// Subscribe subscribes to a subject using a durable consumer on the source stream, if group is empty a normal subscription is done
+func (j *JetStream) Subscribe(subject string, qgroup string, cb MsgHandler, opts SubscribeOptions) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.js == nil {
+		return fmt.Errorf("not connected to JetStream")
+	}
+
+	err := j.ensureConsumer(subject, qgroup, opts)
+	if err != nil {
+		return fmt.Errorf("could not create consumer %s on stream %s: %s", opts.Durable, j.cfg.SourceStream, err)
+	}
+
+	handler := func(m *nats.Msg) {
+		msg, err := newJetStreamMsg(m)
+		if err != nil {
+			j.log.Errorf("Discarding message received on %s: %s", m.Subject, err)
+			return
+		}
+
+		cb(msg)
+	}
+
+	bind := nats.Bind(j.cfg.SourceStream, opts.Durable)
+
+	var sub *nats.Subscription
+
+	switch {
+	case j.cfg.SourcePull:
+		j.log.Infof("Subscribing to subject %s using pull consumer %s", subject, opts.Durable)
+
+		sub, err = j.js.PullSubscribe(subject, opts.Durable, bind)
+		if err != nil {
+			return err
+		}
+
+		go j.fetcher(sub, handler, opts.MaxInflight)
+
+	case qgroup == "":
+		j.log.Infof("Subscribing to subject %s using consumer %s", subject, opts.Durable)
+		sub, err = j.js.Subscribe(subject, handler, bind, nats.ManualAck())
+
+	default:
+		j.log.Infof("Subscribing to subject %s in group %s using consumer %s", subject, qgroup, opts.Durable)
+		sub, err = j.js.QueueSubscribe(subject, qgroup, handler, bind, nats.ManualAck())
+	}
+
+	if err != nil {
+		return err
+	}
+
+	j.subs = append(j.subs, sub)
+
+	return nil
+}
+
+// CloseSubscriptions stops all subscriptions, consumers are bound to rather
+// than created by the subscriptions so they are left in place
+func (j *JetStream) CloseSubscriptions() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var err error
+
+	for _, sub := range j.subs {
+		uerr := sub.Unsubscribe()
+		if uerr != nil {
+			err = uerr
+		}
+	}
+
+	j.subs = nil
+
+	return err
+}
+
+// Close closes the connection, durable consumers are left in place
+func (j *JetStream) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.nc != nil {
+		j.nc.Close()
+	}
+
+	j.setState(StateClosed)
+
+	return nil
+}
+
+// jsContext is the JetStream context, mu is not held while publishing so publishes
+// waiting for acknowledgement do not block each other
+func (j *JetStream) jsContext() (nats.JetStreamContext, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.js == nil {
+		return nil, fmt.Errorf("not connected to JetStream")
+	}
+
+	return j.js, nil
+}
+
+// Publish publishes data to a specific subject and waits for the stream to acknowledge it
+func (j *JetStream) Publish(subject string, data []byte) error {
+	js, err := j.jsContext()
+	if err != nil {
+		return err
+	}
+
+	_, err = js.Publish(subject, data)
+
+	return err
+}
+
+// PublishWithID publishes data to a specific subject setting the Nats-Msg-Id header to id,
+// the stream will discard messages with an id it has already seen within its duplicate window
+func (j *JetStream) PublishWithID(subject string, id string, data []byte) error {
+	js, err := j.jsContext()
+	if err != nil {
+		return err
+	}
+
+	_, err = js.Publish(subject, data, nats.MsgId(id))
+
+	return err
+}
+
+// PublishAsync publishes data to a specific subject without waiting for the
+// stream to acknowledge it, cb is called in a new go routine once the ack or
+// an error is received
+func (j *JetStream) PublishAsync(subject string, data []byte, cb func(err error)) error {
+	js, err := j.jsContext()
+	if err != nil {
+		return err
+	}
+
+	f, err := js.PublishAsync(subject, data)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		select {
+		case <-f.Ok():
+			cb(nil)
+		case err := <-f.Err():
+			cb(err)
+		}
+	}()
+
+	return nil
+}
+
+// ensureConsumer creates the durable consumer unless it exists, push consumers deliver to
+// a subject derived from their name so every worker and replicator creates the same
+// consumer and one created by another replicator in the meantime is used
+func (j *JetStream) ensureConsumer(subject string, qgroup string, opts SubscribeOptions) error {
+	consumerMu.Lock()
+	defer consumerMu.Unlock()
+
+	_, err := j.js.ConsumerInfo(j.cfg.SourceStream, opts.Durable)
+	if err == nil {
+		return nil
+	}
+
+	if err != nats.ErrConsumerNotFound {
+		return err
+	}
+
+	cfg := &nats.ConsumerConfig{
+		Durable:       opts.Durable,
+		DeliverPolicy: nats.DeliverAllPolicy,
+		AckPolicy:     nats.AckExplicitPolicy,
+		AckWait:       opts.AckWait,
+		FilterSubject: subject,
+		MaxAckPending: opts.MaxInflight,
+	}
+
+	switch {
+	case opts.StartSequence > 0:
+		cfg.DeliverPolicy = nats.DeliverByStartSequencePolicy
+		cfg.OptStartSeq = opts.StartSequence
+	case !opts.StartTime.IsZero():
+		start := opts.StartTime
+		cfg.DeliverPolicy = nats.DeliverByStartTimePolicy
+		cfg.OptStartTime = &start
+	case opts.StartDelta > 0:
+		start := time.Now().Add(-opts.StartDelta)
+		cfg.DeliverPolicy = nats.DeliverByStartTimePolicy
+		cfg.OptStartTime = &start
+	case opts.LastReceived:
+		cfg.DeliverPolicy = nats.DeliverLastPolicy
+	case opts.NewOnly:
+		cfg.DeliverPolicy = nats.DeliverNewPolicy
+	}
+
+	if !j.cfg.SourcePull {
+		cfg.DeliverSubject = deliverSubject(j.cfg.SourceStream, opts.Durable)
+		cfg.DeliverGroup = qgroup
+	}
+
+	j.log.Infof("Creating durable consumer %s on stream %s", opts.Durable, j.cfg.SourceStream)
+
+	_, err = j.js.AddConsumer(j.cfg.SourceStream, cfg)
+	if err == nil {
+		return nil
+	}
+
+	nfo, ierr := j.js.ConsumerInfo(j.cfg.SourceStream, opts.Durable)
+	if ierr != nil {
+		return err
+	}
+
+	if nfo.Config.FilterSubject != cfg.FilterSubject || nfo.Config.DeliverSubject != cfg.DeliverSubject || nfo.Config.DeliverGroup != cfg.DeliverGroup {
+		return fmt.Errorf("consumer %s already exists with a different configuration: %s", opts.Durable, err)
+	}
+
+	return nil
+}
+
+// deliverSubject is the subject a push consumer delivers to, stream and consumer names cannot contain dots
+func deliverSubject(stream string, durable string) string {
+	return fmt.Sprintf("_INBOX.sr.%s.%s", stream, durable)
+}
+
+func (j *JetStream) fetcher(sub *nats.Subscription, handler nats.MsgHandler, batch int) {
+	if batch < 1 {
+		batch = 1
+	}
+
+	try := 0
+
+	for {
+		if j.ctx.Err() != nil || !sub.IsValid() {
+			return
+		}
+
+		msgs, err := sub.Fetch(batch, nats.MaxWait(5*time.Second))
+		if err == nats.ErrTimeout {
+			continue
+		}
+
+		if err != nil {
+			try++
+			j.log.Warnf("%s could not fetch messages from consumer: %s", j.name, err)
+
+			if backoff.FiveSec.InterruptableSleep(j.ctx, try) != nil {
+				return
+			}
+
+			continue
+		}
+
+		try = 0
+
+		for _, msg := range msgs {
+			handler(msg)
+		}
+	}
+}
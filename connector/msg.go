@@ -0,0 +1,59 @@
+package connector
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/stan.go"
+)
+
+// Msg is a message received from either NATS Streaming or JetStream
+type Msg struct {
+	Subject     string
+	Data        []byte
+	Sequence    uint64
+	Timestamp   time.Time
+	Redelivered bool
+
+	ack func() error
+}
+
+// MsgHandler handles messages received on a subscription
+type MsgHandler func(msg *Msg)
+
+// Ack acknowledges the message with the stream it was received from
+func (m *Msg) Ack() error {
+	if m.ack == nil {
+		return nil
+	}
+
+	return m.ack()
+}
+
+func newStanMsg(m *stan.Msg) *Msg {
+	return &Msg{
+		Subject:     m.Subject,
+		Data:        m.Data,
+		Sequence:    m.Sequence,
+		Timestamp:   time.Unix(0, m.Timestamp),
+		Redelivered: m.Redelivered,
+		ack:         m.Ack,
+	}
+}
+
+func newJetStreamMsg(m *nats.Msg) (*Msg, error) {
+	meta, err := m.Metadata()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JetStream message: %s", err)
+	}
+
+	return &Msg{
+		Subject:     m.Subject,
+		Data:        m.Data,
+		Sequence:    meta.Sequence.Stream,
+		Timestamp:   meta.Timestamp,
+		Redelivered: meta.NumDelivered > 1,
+		ack:         func() error { return m.AckSync() },
+	}, nil
+}
@@ -1,24 +1,91 @@
 package connector
 
 import (
+	"time"
+
 	"github.com/nats-io/stan.go"
 )
 
+// SubscribeOptions configures a durable subscription on either kind of stream,
+// the start position only applies when the durable is first created and at most
+// one start position should be set, by default all available messages are delivered
+type SubscribeOptions struct {
+	// Durable is the name of the durable subscription or consumer
+	Durable string
+
+	// MaxInflight is the maximum number of unacknowledged messages
+	MaxInflight int
+
+	// AckWait is how long the server waits for an ack before redelivering
+	AckWait time.Duration
+
+	// StartSequence starts delivery at a specific sequence
+	StartSequence uint64
+
+	// StartTime starts delivery at the first message received at or after a time
+	StartTime time.Time
+
+	// StartDelta starts delivery at the first message received this long ago
+	StartDelta time.Duration
+
+	// LastReceived starts delivery at the last message received
+	LastReceived bool
+
+	// NewOnly only delivers messages received after subscribing
+	NewOnly bool
+}
+
 type subscription struct {
 	subject string
 	group   string
-	cb      stan.MsgHandler
-	opts    []stan.SubscriptionOption
+	cb      MsgHandler
+	opts    SubscribeOptions
 	sub     stan.Subscription
 }
 
+func (s *subscription) stanOptions() []stan.SubscriptionOption {
+	opts := []stan.SubscriptionOption{
+		stan.DurableName(s.opts.Durable),
+		stan.SetManualAckMode(),
+	}
+
+	switch {
+	case s.opts.StartSequence > 0:
+		opts = append(opts, stan.StartAtSequence(s.opts.StartSequence))
+	case !s.opts.StartTime.IsZero():
+		opts = append(opts, stan.StartAtTime(s.opts.StartTime))
+	case s.opts.StartDelta > 0:
+		opts = append(opts, stan.StartAtTimeDelta(s.opts.StartDelta))
+	case s.opts.LastReceived:
+		opts = append(opts, stan.StartWithLastReceived())
+	case s.opts.NewOnly:
+		// the default for NATS Streaming subscriptions
+	default:
+		opts = append(opts, stan.DeliverAllAvailable())
+	}
+
+	if s.opts.MaxInflight > 0 {
+		opts = append(opts, stan.MaxInflight(s.opts.MaxInflight))
+	}
+
+	if s.opts.AckWait > 0 {
+		opts = append(opts, stan.AckWait(s.opts.AckWait))
+	}
+
+	return opts
+}
+
 func (s *subscription) subscribe(c *Connection) (err error) {
+	handler := func(m *stan.Msg) {
+		s.cb(newStanMsg(m))
+	}
+
 	if s.group == "" {
 		c.log.Infof("Subscribing to subject %s", s.subject)
-		s.sub, err = c.conn.Subscribe(c.cfg.Topic, s.cb, s.opts...)
+		s.sub, err = c.conn.Subscribe(c.cfg.Topic, handler, s.stanOptions()...)
 	} else {
 		c.log.Infof("Subscribing to subject %s in group %s", s.subject, s.group)
-		s.sub, err = c.conn.QueueSubscribe(c.cfg.Topic, s.group, s.cb, s.opts...)
+		s.sub, err = c.conn.QueueSubscribe(c.cfg.Topic, s.group, handler, s.stanOptions()...)
 	}
 
 	return
@@ -27,6 +27,30 @@ func RunNatsServer(host string, port int) *gnatsd.Server {
 	return s
 }
 
+func RunJetStreamServer(host string, port int, storeDir string) *gnatsd.Server {
+	if host == "" {
+		host = "localhost"
+	}
+
+	opts := &gnatsd.Options{
+		Host:           host,
+		Port:           port,
+		NoSigs:         true,
+		MaxControlLine: 256,
+		JetStream:      true,
+		StoreDir:       storeDir,
+	}
+
+	s, err := gnatsd.NewServer(opts)
+	if err != nil {
+		panic(err)
+	}
+
+	go s.Start()
+
+	return s
+}
+
 func RunLeftServer(url string) *stan.StanServer {
 	sopts := stan.GetDefaultOptions()
 	sopts.ID = "left"
@@ -0,0 +1,145 @@
+package envelope
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/klauspost/compress/gzip"
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// GzipCompression compresses data using gzip
+	GzipCompression = "gzip"
+
+	// ZstdCompression compresses data using zstandard
+	ZstdCompression = "zstd"
+
+	// S2Compression compresses data using s2, a faster snappy compatible algorithm
+	S2Compression = "s2"
+)
+
+var (
+	zenc    *zstd.Encoder
+	zdec    *zstd.Decoder
+	zerr    error
+	zstdSet sync.Once
+)
+
+// zstd encoders and decoders are expensive to create and safe for concurrent use
+func zstdCoders() (*zstd.Encoder, *zstd.Decoder, error) {
+	zstdSet.Do(func() {
+		zenc, zerr = zstd.NewWriter(nil)
+		if zerr != nil {
+			return
+		}
+
+		zdec, zerr = zstd.NewReader(nil)
+	})
+
+	return zenc, zdec, zerr
+}
+
+// ValidCompression determines if algo is a supported compression algorithm
+func ValidCompression(algo string) bool {
+	switch algo {
+	case GzipCompression, ZstdCompression, S2Compression:
+		return true
+	default:
+		return false
+	}
+}
+
+// Compress compresses the data in the envelope using algo, the algorithm is recorded
+// in the envelope so that Unwrap can restore the original data
+func (e *V1) Compress(algo string) error {
+	if e.Compression != "" {
+		return fmt.Errorf("data is already compressed using %s", e.Compression)
+	}
+
+	var out []byte
+
+	switch algo {
+	case GzipCompression:
+		buf := &bytes.Buffer{}
+		w := gzip.NewWriter(buf)
+
+		_, err := w.Write(e.Data)
+		if err != nil {
+			return err
+		}
+
+		err = w.Close()
+		if err != nil {
+			return err
+		}
+
+		out = buf.Bytes()
+
+	case ZstdCompression:
+		enc, _, err := zstdCoders()
+		if err != nil {
+			return err
+		}
+
+		out = enc.EncodeAll(e.Data, nil)
+
+	case S2Compression:
+		out = s2.Encode(nil, e.Data)
+
+	default:
+		return fmt.Errorf("unknown compression algorithm %q", algo)
+	}
+
+	e.Data = out
+	e.Compression = algo
+
+	return nil
+}
+
+// Decompress restores the original data of a compressed envelope
+func (e *V1) Decompress() error {
+	var out []byte
+	var err error
+
+	switch e.Compression {
+	case "":
+		return nil
+
+	case GzipCompression:
+		var r *gzip.Reader
+		r, err = gzip.NewReader(bytes.NewReader(e.Data))
+		if err != nil {
+			break
+		}
+
+		out, err = ioutil.ReadAll(r)
+
+	case ZstdCompression:
+		var dec *zstd.Decoder
+		_, dec, err = zstdCoders()
+		if err != nil {
+			break
+		}
+
+		out, err = dec.DecodeAll(e.Data, nil)
+
+	case S2Compression:
+		out, err = s2.Decode(nil, e.Data)
+
+	default:
+		return fmt.Errorf("unknown compression algorithm %q", e.Compression)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not decompress %s data: %s", e.Compression, err)
+	}
+
+	e.Data = out
+	e.Compression = ""
+
+	return nil
+}
@@ -0,0 +1,45 @@
+package envelope
+
+import (
+	"bytes"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Compression", func() {
+	data := bytes.Repeat([]byte(`{"hello":"world"}`), 100)
+
+	for _, algo := range []string{GzipCompression, ZstdCompression, S2Compression} {
+		algo := algo
+
+		It("Should compress and decompress using "+algo, func() {
+			env := New("dc1", data)
+			Expect(env.Compress(algo)).To(Succeed())
+			Expect(env.Compression).To(Equal(algo))
+			Expect(len(env.Data)).To(BeNumerically("<", len(data)))
+			Expect(env.Compress(algo)).To(MatchError("data is already compressed using " + algo))
+
+			for _, format := range []string{JSONFormat, RawFormat} {
+				b, err := env.Encode(format)
+				Expect(err).ToNot(HaveOccurred())
+
+				parsed, err := Unwrap(b)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(parsed.Compression).To(BeEmpty())
+				Expect(parsed.Data).To(Equal(data))
+			}
+		})
+	}
+
+	It("Should reject unknown algorithms", func() {
+		Expect(ValidCompression("lz4")).To(BeFalse())
+		Expect(New("dc1", data).Compress("lz4")).To(MatchError(`unknown compression algorithm "lz4"`))
+	})
+
+	It("Should detect corrupt data", func() {
+		env := New("dc1", []byte("not gzip"))
+		env.Compression = GzipCompression
+		Expect(env.Decompress()).To(MatchError("could not decompress gzip data: unexpected EOF"))
+	})
+})
@@ -0,0 +1,183 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+const (
+	// AESGCMEncryption encrypts data using AES-GCM with a shared key
+	AESGCMEncryption = "aes-gcm"
+
+	// RSAAESGCMEncryption encrypts data using AES-GCM with a random key that
+	// is encrypted to the RSA public key of the recipient using OAEP
+	RSAAESGCMEncryption = "rsa-oaep-aes-gcm"
+)
+
+// Encrypt encrypts the data in the envelope using AES-GCM and a shared key
+// of 16, 24 or 32 bytes, the data should be compressed before encryption
+func (e *V1) Encrypt(key []byte) error {
+	if e.Encryption != "" {
+		return fmt.Errorf("data is already encrypted using %s", e.Encryption)
+	}
+
+	e.Encryption = AESGCMEncryption
+
+	return e.seal(key)
+}
+
+// EncryptForCertificate encrypts the data in the envelope so that only the holder of
+// the private key matching cert can decrypt it, the certificate should hold a RSA key
+func (e *V1) EncryptForCertificate(cert *x509.Certificate) error {
+	if e.Encryption != "" {
+		return fmt.Errorf("data is already encrypted using %s", e.Encryption)
+	}
+
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("certificate %s does not hold a RSA public key", cert.Subject.CommonName)
+	}
+
+	key := make([]byte, 32)
+	_, err := io.ReadFull(rand.Reader, key)
+	if err != nil {
+		return err
+	}
+
+	ekey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return err
+	}
+
+	e.Key = ekey
+	e.Encryption = RSAAESGCMEncryption
+
+	return e.seal(key)
+}
+
+// Decrypt decrypts data that was encrypted using a shared key and decompresses it
+func (e *V1) Decrypt(key []byte) error {
+	if e.Encryption != AESGCMEncryption {
+		return fmt.Errorf("data is not encrypted using %s", AESGCMEncryption)
+	}
+
+	return e.open(key)
+}
+
+// DecryptWithPrivateKey decrypts data that was encrypted to a certificate and decompresses it
+func (e *V1) DecryptWithPrivateKey(pk *rsa.PrivateKey) error {
+	if e.Encryption != RSAAESGCMEncryption {
+		return fmt.Errorf("data is not encrypted using %s", RSAAESGCMEncryption)
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, pk, e.Key, nil)
+	if err != nil {
+		return fmt.Errorf("could not decrypt data key: %s", err)
+	}
+
+	return e.open(key)
+}
+
+// additionalData is the metadata that is authenticated along with the encrypted data
+// so it cannot be changed undetected, it is the header as it was when encrypting
+func (e *V1) additionalData() ([]byte, error) {
+	if e.SealedHops > len(e.Hops) {
+		return nil, fmt.Errorf("envelope has %d hops but %d were sealed", len(e.Hops), e.SealedHops)
+	}
+
+	header := *e
+	header.Data = nil
+	header.Hops = e.Hops[:e.SealedHops]
+	header.HopCount = e.SealedHops
+
+	return json.Marshal(header)
+}
+
+// seal encrypts the data with key, the encryption settings should be set
+func (e *V1) seal(key []byte) error {
+	e.SealedHops = len(e.Hops)
+
+	ad, err := e.additionalData()
+	if err == nil {
+		e.Data, err = sealAESGCM(key, e.Data, ad)
+	}
+
+	if err != nil {
+		e.Encryption = ""
+		e.Key = nil
+		e.SealedHops = 0
+		return err
+	}
+
+	return nil
+}
+
+// open decrypts the data with key and decompresses it
+func (e *V1) open(key []byte) error {
+	ad, err := e.additionalData()
+	if err != nil {
+		return err
+	}
+
+	out, err := openAESGCM(key, e.Data, ad)
+	if err != nil {
+		return err
+	}
+
+	e.Data = out
+	e.Key = nil
+	e.Encryption = ""
+	e.SealedHops = 0
+
+	return e.Decompress()
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// sealAESGCM encrypts data and prefixes it with the random nonce used, ad is authenticated but not encrypted
+func sealAESGCM(key []byte, data []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize(), gcm.NonceSize()+len(data)+gcm.Overhead())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, data, ad), nil
+}
+
+func openAESGCM(key []byte, data []byte, ad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, fmt.Errorf("encrypted data is truncated")
+	}
+
+	out, err := gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], ad)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt data: %s", err)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,115 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encryption", func() {
+	data := bytes.Repeat([]byte(`{"hello":"world"}`), 100)
+	key := bytes.Repeat([]byte{1}, 32)
+
+	It("Should encrypt using a shared key", func() {
+		env := New("dc1", data)
+		Expect(env.Compress(S2Compression)).To(Succeed())
+		Expect(env.Encrypt(key)).To(Succeed())
+		Expect(env.Encryption).To(Equal(AESGCMEncryption))
+		Expect(env.Encrypt(key)).To(MatchError("data is already encrypted using aes-gcm"))
+
+		b, err := env.Raw()
+		Expect(err).ToNot(HaveOccurred())
+
+		parsed, err := Unwrap(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Encryption).To(Equal(AESGCMEncryption))
+		Expect(parsed.Compression).To(Equal(S2Compression))
+
+		Expect(parsed.Decrypt(bytes.Repeat([]byte{2}, 32))).To(MatchError("could not decrypt data: cipher: message authentication failed"))
+		Expect(parsed.Decrypt(key)).To(Succeed())
+		Expect(parsed.Encryption).To(BeEmpty())
+		Expect(parsed.Compression).To(BeEmpty())
+		Expect(parsed.Data).To(Equal(data))
+	})
+
+	It("Should encrypt to a certificate", func() {
+		pk, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			Subject:      pkix.Name{CommonName: "dc2.replicator"},
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pk.PublicKey, pk)
+		Expect(err).ToNot(HaveOccurred())
+
+		cert, err := x509.ParseCertificate(der)
+		Expect(err).ToNot(HaveOccurred())
+
+		env := New("dc1", data)
+		Expect(env.EncryptForCertificate(cert)).To(Succeed())
+		Expect(env.Encryption).To(Equal(RSAAESGCMEncryption))
+		Expect(env.Key).ToNot(BeEmpty())
+
+		b, err := env.Bytes()
+		Expect(err).ToNot(HaveOccurred())
+
+		parsed, err := Unwrap(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Decrypt(key)).To(MatchError("data is not encrypted using aes-gcm"))
+		Expect(parsed.DecryptWithPrivateKey(pk)).To(Succeed())
+		Expect(parsed.Data).To(Equal(data))
+	})
+
+	It("Should authenticate the metadata", func() {
+		encrypted := func() *V1 {
+			env := New("dc1", data)
+			env.AddHop("dc1")
+			Expect(env.Compress(S2Compression)).To(Succeed())
+			Expect(env.Encrypt(key)).To(Succeed())
+			Expect(env.SealedHops).To(Equal(1))
+
+			b, err := env.Bytes()
+			Expect(err).ToNot(HaveOccurred())
+
+			parsed, err := Unwrap(b)
+			Expect(err).ToNot(HaveOccurred())
+
+			return parsed
+		}
+
+		env := encrypted()
+		env.Origin = "dc2"
+		Expect(env.Decrypt(key)).To(MatchError("could not decrypt data: cipher: message authentication failed"))
+
+		env = encrypted()
+		env.Hops[0] = "dc2"
+		Expect(env.Decrypt(key)).To(MatchError("could not decrypt data: cipher: message authentication failed"))
+
+		env = encrypted()
+		env.Compression = ""
+		Expect(env.Decrypt(key)).To(MatchError("could not decrypt data: cipher: message authentication failed"))
+
+		env = encrypted()
+		env.Hops = nil
+		Expect(env.Decrypt(key)).To(MatchError("envelope has 0 hops but 1 were sealed"))
+
+		// replicators copying encrypted data as is add hops
+		env = encrypted()
+		env.AddHop("dc2")
+		Expect(env.Decrypt(key)).To(Succeed())
+		Expect(env.Hops).To(Equal([]string{"dc1", "dc2"}))
+		Expect(env.SealedHops).To(BeZero())
+		Expect(env.Data).To(Equal(data))
+	})
+})
@@ -0,0 +1,187 @@
+// Package envelope wraps replicated messages with information about where they
+// came from, consumers of replicated topics can use Unwrap to access the original
+// message and its metadata
+package envelope
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	// Schema identifies JSON envelopes
+	Schema = "https://choria.io/schemas/sr/v1/envelope.json"
+
+	// JSONFormat wraps the message in a JSON document with the data base64 encoded
+	JSONFormat = "json"
+
+	// RawFormat leaves the message as is and appends the metadata as a trailer
+	RawFormat = "raw"
+)
+
+var (
+	prefix = []byte(`{"$schema":"` + Schema + `"`)
+
+	// raw envelopes end with a JSON header, its length and this marker
+	trailer = []byte("\x00SRENV1")
+)
+
+// V1 is a replicated message along with metadata about where it originated and the
+// clusters it passed through, the origin metadata is set by the first replicator
+// that copied the message and kept as is by later ones
+type V1 struct {
+	Version     string    `json:"$schema"`
+	Origin      string    `json:"origin"`
+	Subject     string    `json:"subject"`
+	Sequence    uint64    `json:"sequence"`
+	Timestamp   time.Time `json:"timestamp"`
+	Replicator  string    `json:"replicator"`
+	HopCount    int       `json:"hop_count"`
+	Hops        []string  `json:"hops"`
+	Compression string    `json:"compression,omitempty"`
+	Encryption  string    `json:"encryption,omitempty"`
+	Key         []byte    `json:"key,omitempty"`
+	Data        []byte    `json:"data,omitempty"`
+
+	// SealedHops is the number of hops when the data was encrypted, those hops are
+	// authenticated along with the other metadata while hops added later by
+	// replicators copying the encrypted data as is are not
+	SealedHops int `json:"sealed_hops,omitempty"`
+}
+
+// New creates an envelope for data that originated on the origin cluster
+func New(origin string, data []byte) *V1 {
+	return &V1{
+		Version: Schema,
+		Origin:  origin,
+		Hops:    []string{},
+		Data:    data,
+	}
+}
+
+// IsEnvelope determines if data is a message wrapped in an envelope of any format
+func IsEnvelope(data []byte) bool {
+	return bytes.HasPrefix(data, prefix) || bytes.HasSuffix(data, trailer)
+}
+
+// Unwrap parses an envelope of any format and decompresses its data, data that is
+// not an envelope results in an error.  Encrypted data is left as is and should be
+// decrypted using Decrypt or DecryptWithPrivateKey which also decompresses it
+func Unwrap(data []byte) (*V1, error) {
+	var env *V1
+	var err error
+
+	switch {
+	case bytes.HasPrefix(data, prefix):
+		env = &V1{}
+		err = json.Unmarshal(data, env)
+		if err != nil {
+			return nil, fmt.Errorf("invalid envelope: %s", err)
+		}
+
+	case bytes.HasSuffix(data, trailer):
+		env, err = unwrapRaw(data)
+		if err != nil {
+			return nil, err
+		}
+
+	default:
+		return nil, fmt.Errorf("data is not a replicator envelope")
+	}
+
+	if env.Encryption != "" {
+		return env, nil
+	}
+
+	err = env.Decompress()
+	if err != nil {
+		return nil, err
+	}
+
+	return env, nil
+}
+
+func unwrapRaw(data []byte) (*V1, error) {
+	end := len(data) - len(trailer) - 4
+	if end < 0 {
+		return nil, fmt.Errorf("invalid envelope: trailer is truncated")
+	}
+
+	hlen := int(binary.BigEndian.Uint32(data[end:]))
+	if hlen > end {
+		return nil, fmt.Errorf("invalid envelope: header length %d exceeds message size", hlen)
+	}
+
+	env := &V1{}
+	err := json.Unmarshal(data[end-hlen:end], env)
+	if err != nil {
+		return nil, fmt.Errorf("invalid envelope: %s", err)
+	}
+
+	env.Data = data[:end-hlen]
+
+	return env, nil
+}
+
+// AddHop records that the message was copied from cluster
+func (e *V1) AddHop(cluster string) {
+	e.Hops = append(e.Hops, cluster)
+	e.HopCount = len(e.Hops)
+}
+
+// Visited determines if the message originated on or was copied from cluster
+func (e *V1) Visited(cluster string) bool {
+	if e.Origin == cluster {
+		return true
+	}
+
+	for _, hop := range e.Hops {
+		if hop == cluster {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Bytes encodes the envelope to JSON
+func (e *V1) Bytes() ([]byte, error) {
+	return json.Marshal(e)
+}
+
+// Raw encodes the envelope as the original data followed by a trailer holding the metadata
+func (e *V1) Raw() ([]byte, error) {
+	header := *e
+	header.Data = nil
+
+	hj, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	hlen := make([]byte, 4)
+	binary.BigEndian.PutUint32(hlen, uint32(len(hj)))
+
+	out := make([]byte, 0, len(e.Data)+len(hj)+len(hlen)+len(trailer))
+	out = append(out, e.Data...)
+	out = append(out, hj...)
+	out = append(out, hlen...)
+	out = append(out, trailer...)
+
+	return out, nil
+}
+
+// Encode encodes the envelope in the given format
+func (e *V1) Encode(format string) ([]byte, error) {
+	switch format {
+	case JSONFormat:
+		return e.Bytes()
+	case RawFormat:
+		return e.Raw()
+	default:
+		return nil, fmt.Errorf("unknown envelope format %q", format)
+	}
+}
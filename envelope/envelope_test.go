@@ -0,0 +1,69 @@
+package envelope
+
+import (
+	"testing"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFederation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Envelope")
+}
+
+var _ = Describe("Envelope", func() {
+	It("Should round trip envelopes", func() {
+		env := New("dc1", []byte(`{"hello":"world"}`))
+		env.AddHop("dc1")
+
+		b, err := env.Bytes()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(IsEnvelope(b)).To(BeTrue())
+
+		parsed, err := Unwrap(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Origin).To(Equal("dc1"))
+		Expect(parsed.Hops).To(Equal([]string{"dc1"}))
+		Expect(parsed.Data).To(Equal([]byte(`{"hello":"world"}`)))
+	})
+
+	It("Should round trip raw envelopes", func() {
+		env := New("dc1", []byte("hello world"))
+		env.Sequence = 10
+		env.AddHop("dc1")
+
+		b, err := env.Raw()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b).To(HavePrefix("hello world"))
+		Expect(IsEnvelope(b)).To(BeTrue())
+
+		parsed, err := Unwrap(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(parsed.Origin).To(Equal("dc1"))
+		Expect(parsed.Sequence).To(Equal(uint64(10)))
+		Expect(parsed.HopCount).To(Equal(1))
+		Expect(parsed.Data).To(Equal([]byte("hello world")))
+	})
+
+	It("Should detect truncated raw envelopes", func() {
+		_, err := Unwrap([]byte("\x00SRENV1"))
+		Expect(err).To(MatchError("invalid envelope: trailer is truncated"))
+	})
+
+	It("Should not unwrap other data", func() {
+		Expect(IsEnvelope([]byte(`{"hello":"world"}`))).To(BeFalse())
+
+		_, err := Unwrap([]byte(`{"hello":"world"}`))
+		Expect(err).To(MatchError("data is not a replicator envelope"))
+	})
+
+	It("Should know which clusters were visited", func() {
+		env := New("dc1", nil)
+		env.AddHop("dc2")
+
+		Expect(env.Visited("dc1")).To(BeTrue())
+		Expect(env.Visited("dc2")).To(BeTrue())
+		Expect(env.Visited("dc3")).To(BeFalse())
+	})
+})
@@ -6,38 +6,80 @@ import (
 	"sync"
 	"time"
 
+	"github.com/choria-io/stream-replicator/advisor"
 	"github.com/choria-io/stream-replicator/config"
-	"github.com/nats-io/stan.go"
+	"github.com/choria-io/stream-replicator/connector"
 )
 
-var inspecter Inspecter
-
 type Inspecter interface {
-	Configure(ctx context.Context, wg *sync.WaitGroup, inspectKey string, updateFlagKey string, age time.Duration, topic string) error
-	ProcessAndRecord(msg *stan.Msg, f func(msg *stan.Msg, process bool) error) error
+	Configure(ctx context.Context, wg *sync.WaitGroup, inspectKey string, updateFlagKey string, age time.Duration, topic string, adv *advisor.Advisor) error
+	ProcessAndRecord(msg *connector.Msg, f func(msg *connector.Msg, process bool) error) error
+	Flush() error
+	Size() int
+	SetAge(age time.Duration)
+}
+
+// Limiter decides if messages should be processed using an Inspecter,
+// each replicated topic has its own limiter
+type Limiter struct {
+	inspecter Inspecter
 }
 
-// Configure configures a given limiter
-func Configure(ctx context.Context, wg *sync.WaitGroup, c *config.TopicConf, ins Inspecter) error {
+// New creates a limiter using a configured inspecter
+func New(ctx context.Context, wg *sync.WaitGroup, c *config.TopicConf, ins Inspecter, adv *advisor.Advisor) (*Limiter, error) {
 	d, err := time.ParseDuration(c.MinAge)
 	if err != nil {
-		return fmt.Errorf("could not parse duration '%s': %s", c.MinAge, err)
+		return nil, fmt.Errorf("could not parse duration '%s': %s", c.MinAge, err)
 	}
 
-	err = ins.Configure(ctx, wg, c.Inspect, c.UpdateFlag, d, c.Name)
+	err = ins.Configure(ctx, wg, c.Inspect, c.UpdateFlag, d, c.Name, adv)
 	if err != nil {
-		return fmt.Errorf("could not configure inspecter: %s", err)
+		return nil, fmt.Errorf("could not configure inspecter: %s", err)
+	}
+
+	return &Limiter{inspecter: ins}, nil
+}
+
+// Process calls f with a decision if msg should be processed, without
+// an inspecter every message is processed
+func (l *Limiter) Process(msg *connector.Msg, f func(msg *connector.Msg, process bool) error) error {
+	if l == nil || l.inspecter == nil {
+		return f(msg, true)
 	}
 
-	inspecter = ins
+	return l.inspecter.ProcessAndRecord(msg, f)
+}
+
+// Flush saves any state the inspecter keeps
+func (l *Limiter) Flush() error {
+	if l == nil || l.inspecter == nil {
+		return nil
+	}
+
+	return l.inspecter.Flush()
+}
+
+// Update applies a new minimum age from the configuration while running
+func (l *Limiter) Update(c *config.TopicConf) error {
+	d, err := time.ParseDuration(c.MinAge)
+	if err != nil {
+		return fmt.Errorf("could not parse duration '%s': %s", c.MinAge, err)
+	}
+
+	if l == nil || l.inspecter == nil {
+		return nil
+	}
+
+	l.inspecter.SetAge(d)
 
 	return nil
 }
 
-func Process(msg *stan.Msg, f func(msg *stan.Msg, process bool) error) error {
-	if inspecter == nil {
-		return f(msg, true)
+// Size is the number of values the inspecter is tracking
+func (l *Limiter) Size() int {
+	if l == nil || l.inspecter == nil {
+		return 0
 	}
 
-	return inspecter.ProcessAndRecord(msg, f)
+	return l.inspecter.Size()
 }
@@ -13,7 +13,7 @@ import (
 	"github.com/choria-io/stream-replicator/advisor"
 
 	"github.com/choria-io/stream-replicator/config"
-	stan "github.com/nats-io/stan.go"
+	"github.com/choria-io/stream-replicator/connector"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 	"github.com/tidwall/gjson"
@@ -35,6 +35,7 @@ type Limiter struct {
 	processed  map[string]time.Time
 	mu         *sync.Mutex
 	log        *logrus.Entry
+	advisor    *advisor.Advisor
 }
 
 var seenGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
@@ -64,8 +65,9 @@ func init() {
 	prometheus.MustRegister(errCtr)
 }
 
-func (m *Limiter) Configure(ctx context.Context, wg *sync.WaitGroup, inspectKey string, updateFlagKey string, age time.Duration, topic string) error {
+func (m *Limiter) Configure(ctx context.Context, wg *sync.WaitGroup, inspectKey string, updateFlagKey string, age time.Duration, topic string, adv *advisor.Advisor) error {
 	m.mu = &sync.Mutex{}
+	m.advisor = adv
 	m.updateFlag = updateFlagKey
 	m.key = inspectKey
 	m.age = age
@@ -90,7 +92,7 @@ func (m *Limiter) Configure(ctx context.Context, wg *sync.WaitGroup, inspectKey
 	return nil
 }
 
-func (m *Limiter) ProcessAndRecord(msg *stan.Msg, f func(msg *stan.Msg, process bool) error) error {
+func (m *Limiter) ProcessAndRecord(msg *connector.Msg, f func(msg *connector.Msg, process bool) error) error {
 	if m.key == "" {
 		passedCtr.WithLabelValues(m.key, m.topic).Inc()
 		return f(msg, true)
@@ -127,7 +129,7 @@ func (m *Limiter) ProcessAndRecord(msg *stan.Msg, f func(msg *stan.Msg, process
 	// this might combine many different incorrect data items into
 	// one bucket
 	if value != "" {
-		advisor.Record(value)
+		m.advisor.Record(value)
 	}
 
 	err := f(msg, process)
@@ -200,7 +202,7 @@ func (m *Limiter) readCache() error {
 			continue
 		}
 
-		advisor.RecordTime(i, t)
+		m.advisor.RecordTime(i, t)
 	}
 
 	m.log.Infof("Read %d bytes of last-processed data from cache file %s.  After scrubbing old entries the last-processed data has %d entries.", len(d), m.statefile, len(m.processed))
@@ -253,6 +255,31 @@ func (m *Limiter) writeCache() error {
 	return nil
 }
 
+// Flush writes the last seen data to the state file when one is configured
+func (m *Limiter) Flush() error {
+	if m.statefile == "" {
+		return nil
+	}
+
+	return m.writeCache()
+}
+
+// SetAge adjusts how long a value is tracked before it is processed again
+func (m *Limiter) SetAge(age time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.age = age
+}
+
+// Size is the number of unique values being tracked
+func (m *Limiter) Size() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.processed)
+}
+
 func (m *Limiter) cacher(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
@@ -9,6 +9,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/choria-io/stream-replicator/advisor"
 	"github.com/choria-io/stream-replicator/config"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
@@ -41,7 +42,7 @@ var _ = Describe("Limiter/Memory", func() {
 			log: logrus.WithFields(logrus.Fields{}),
 		}
 
-		m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test")
+		m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test", &advisor.Advisor{})
 	})
 
 	AfterEach(func() {
@@ -101,18 +102,36 @@ var _ = Describe("Limiter/Memory", func() {
 		It("Should set the statefile if configured", func() {
 			config.Load("testdata/stateconfig.yaml")
 
-			m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test")
+			m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test", &advisor.Advisor{})
 
 			Expect(m.statefile).To(Equal("testdata/test.json"))
 		})
 	})
 
+	var _ = Describe("SetAge", func() {
+		It("Should change when values are processed again", func() {
+			m.processed["test"] = time.Now().Add(-30 * time.Second)
+			Expect(m.shouldProcess("test")).To(BeFalse())
+
+			m.SetAge(10 * time.Second)
+			Expect(m.shouldProcess("test")).To(BeTrue())
+		})
+	})
+
+	var _ = Describe("Size", func() {
+		It("Should count the tracked values", func() {
+			Expect(m.Size()).To(Equal(0))
+			m.processed["test"] = time.Now()
+			Expect(m.Size()).To(Equal(1))
+		})
+	})
+
 	var _ = Describe("writeCache", func() {
 		BeforeEach(func() {
 			config.Load("testdata/stateconfig.yaml")
 			os.Remove("testdata/test.json")
 
-			m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test")
+			m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test", &advisor.Advisor{})
 		})
 
 		It("Should not write when unconfigured", func() {
@@ -141,7 +160,7 @@ var _ = Describe("Limiter/Memory", func() {
 			config.Load("testdata/stateconfig.yaml")
 			os.Remove("testdata/test.json")
 
-			m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test")
+			m.Configure(ctx, wg, "k", "u", time.Duration(1*time.Minute), "test", &advisor.Advisor{})
 
 			m.processed["test"] = time.Now()
 			m.writeCache()
@@ -0,0 +1,177 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/choria-io/stream-replicator/advisor"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/sirupsen/logrus"
+)
+
+// TopicState is the detailed state of a copier reported by the admin api
+type TopicState struct {
+	*Status
+
+	Paused      bool              `json:"paused"`
+	WorkerState []WorkerStatus    `json:"worker_state"`
+	LimiterSize int               `json:"limiter_size"`
+	Advisor     *advisor.State    `json:"advisor,omitempty"`
+	Config      *config.TopicConf `json:"config"`
+}
+
+// State reports the detailed state of the copier including its configuration with credentials redacted
+func (c *Copier) State() *TopicState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	s := &TopicState{
+		Status:      c.status(),
+		Paused:      c.paused,
+		WorkerState: []WorkerStatus{},
+		LimiterSize: c.limiter.Size(),
+		Config:      c.config.Redacted(),
+	}
+
+	for _, w := range c.workers {
+		s.WorkerState = append(s.WorkerState, w.status())
+	}
+
+	if c.advisor != nil {
+		adv := c.advisor.State()
+		s.Advisor = &adv
+	}
+
+	return s
+}
+
+// SetupAdmin serves the admin api on /admin/topics of the monitoring port
+//
+//	GET  /admin/topics                    the state of all topics
+//	GET  /admin/topics/<name>             the state of a topic
+//	POST /admin/topics/<name>/pause       stops receiving messages
+//	POST /admin/topics/<name>/resume      resumes receiving messages
+//	POST /admin/topics/<name>/flush       saves the limiter state
+//	POST /admin/topics/<name>/rate_limit  adjusts the rate limits
+func SetupAdmin(copiers Copiers) {
+	h := newAdminHandler(copiers)
+
+	http.Handle("/admin/topics", h)
+	http.Handle("/admin/topics/", h)
+}
+
+type adminHandler struct {
+	copiers Copiers
+}
+
+func newAdminHandler(copiers Copiers) *adminHandler {
+	return &adminHandler{copiers: copiers}
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/topics"), "/"), "/")
+
+	copiers := make(map[string]*Copier)
+	for _, c := range h.copiers() {
+		copiers[c.config.Name] = c
+	}
+
+	if parts[0] == "" {
+		if r.Method != http.MethodGet {
+			h.error(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not supported", r.Method))
+			return
+		}
+
+		state := make(map[string]*TopicState)
+		for name, c := range copiers {
+			state[name] = c.State()
+		}
+
+		h.respond(w, state)
+		return
+	}
+
+	c, ok := copiers[parts[0]]
+	if !ok {
+		h.error(w, http.StatusNotFound, fmt.Errorf("unknown topic %s", parts[0]))
+		return
+	}
+
+	if len(parts) == 1 {
+		if r.Method != http.MethodGet {
+			h.error(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not supported", r.Method))
+			return
+		}
+
+		h.respond(w, c.State())
+		return
+	}
+
+	if len(parts) > 2 {
+		h.error(w, http.StatusNotFound, fmt.Errorf("unknown action %s", strings.Join(parts[1:], "/")))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		h.error(w, http.StatusMethodNotAllowed, fmt.Errorf("%s is not supported", r.Method))
+		return
+	}
+
+	var err error
+
+	switch parts[1] {
+	case "pause":
+		err = c.Pause()
+
+	case "resume":
+		err = c.Resume()
+
+	case "flush":
+		err = c.Flush()
+
+	case "rate_limit":
+		var rl *config.RateLimitConf
+
+		err = json.NewDecoder(r.Body).Decode(&rl)
+		if err != nil {
+			h.error(w, http.StatusBadRequest, fmt.Errorf("invalid rate limit: %s", err))
+			return
+		}
+
+		err = c.SetRateLimit(rl)
+		if err != nil {
+			h.error(w, http.StatusBadRequest, err)
+			return
+		}
+
+	default:
+		h.error(w, http.StatusNotFound, fmt.Errorf("unknown action %s", parts[1]))
+		return
+	}
+
+	if err != nil {
+		h.error(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	c.Log.Infof("Performed admin action %s", parts[1])
+
+	h.respond(w, c.State())
+}
+
+func (h *adminHandler) respond(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		logrus.Errorf("Could not encode admin response: %s", err)
+	}
+}
+
+func (h *adminHandler) error(w http.ResponseWriter, code int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,89 @@
+package replicator
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/connector"
+)
+
+// DeadLetterV1 is published to the dead letter subject for messages that failed to copy too many times
+type DeadLetterV1 struct {
+	Version    string `json:"$schema"`
+	Replicator string `json:"replicator"`
+	Subject    string `json:"subject"`
+	Sequence   uint64 `json:"sequence"`
+	Attempts   int    `json:"attempts"`
+	Error      string `json:"error"`
+	Data       []byte `json:"data"`
+
+	// OriginalTimestamp is when the original message was published to the source
+	OriginalTimestamp int64 `json:"original_timestamp"`
+
+	// Timestamp is when the dead letter was created
+	Timestamp int64 `json:"timestamp"`
+}
+
+// failureTracker counts how many times messages failed to copy by source sequence
+type failureTracker struct {
+	attempts map[uint64]*failure
+	mu       sync.Mutex
+}
+
+type failure struct {
+	attempts int
+	last     time.Time
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{attempts: make(map[uint64]*failure)}
+}
+
+// Failed records a failed attempt to copy msg and returns the number of attempts so far,
+// messages that are not redeliveries start counting from scratch
+func (t *failureTracker) Failed(msg *connector.Msg) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.attempts[msg.Sequence]
+	if !ok || !msg.Redelivered {
+		f = &failure{}
+		t.attempts[msg.Sequence] = f
+	}
+
+	f.attempts++
+	f.last = time.Now()
+
+	// messages can be redelivered to other workers in a queue group, forget
+	// about the ones that have not been seen in a long time
+	oldest := time.Now().Add(-time.Hour)
+	for seq, f := range t.attempts {
+		if f.last.Before(oldest) {
+			delete(t.attempts, seq)
+		}
+	}
+
+	return f.attempts
+}
+
+// Forget removes the tracking data for msg
+func (t *failureTracker) Forget(msg *connector.Msg) {
+	t.mu.Lock()
+	delete(t.attempts, msg.Sequence)
+	t.mu.Unlock()
+}
+
+func newDeadLetter(name string, msg *connector.Msg, attempts int, reason error) ([]byte, error) {
+	return json.Marshal(DeadLetterV1{
+		Version:           "https://choria.io/schemas/sr/v1/dead_letter.json",
+		Replicator:        name,
+		Subject:           msg.Subject,
+		Sequence:          msg.Sequence,
+		Attempts:          attempts,
+		Error:             reason.Error(),
+		Data:              msg.Data,
+		OriginalTimestamp: msg.Timestamp.Unix(),
+		Timestamp:         time.Now().UTC().Unix(),
+	})
+}
@@ -0,0 +1,56 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/choria-io/stream-replicator/connector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dead Letter", func() {
+	var msg *connector.Msg
+
+	BeforeEach(func() {
+		msg = &connector.Msg{Subject: "acme.cmdb", Sequence: 10, Timestamp: time.Unix(1516987895, 0), Data: []byte("{}")}
+	})
+
+	Describe("failureTracker", func() {
+		It("Should count redelivered failures", func() {
+			t := newFailureTracker()
+			Expect(t.Failed(msg)).To(Equal(1))
+
+			msg.Redelivered = true
+			Expect(t.Failed(msg)).To(Equal(2))
+			Expect(t.Failed(msg)).To(Equal(3))
+
+			t.Forget(msg)
+			Expect(t.Failed(msg)).To(Equal(1))
+		})
+
+		It("Should restart counting for messages that are not redeliveries", func() {
+			t := newFailureTracker()
+			Expect(t.Failed(msg)).To(Equal(1))
+			Expect(t.Failed(msg)).To(Equal(1))
+		})
+	})
+
+	Describe("newDeadLetter", func() {
+		It("Should include the message and failure details", func() {
+			d, err := newDeadLetter("testing", msg, 5, fmt.Errorf("simulated"))
+			Expect(err).ToNot(HaveOccurred())
+
+			dl := DeadLetterV1{}
+			Expect(json.Unmarshal(d, &dl)).To(Succeed())
+			Expect(dl.Replicator).To(Equal("testing"))
+			Expect(dl.Subject).To(Equal("acme.cmdb"))
+			Expect(dl.Sequence).To(Equal(uint64(10)))
+			Expect(dl.OriginalTimestamp).To(Equal(int64(1516987895)))
+			Expect(dl.Attempts).To(Equal(5))
+			Expect(dl.Error).To(Equal("simulated"))
+			Expect(dl.Data).To(Equal([]byte("{}")))
+		})
+	})
+})
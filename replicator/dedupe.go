@@ -0,0 +1,170 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/sirupsen/logrus"
+)
+
+// dedupe tracks the last source sequence that was copied in a state file so that
+// messages redelivered after a crash between publishing and acking are acked without
+// being published again, this relies on messages being copied in order by a single worker
+type dedupe struct {
+	file   string
+	source string
+	last   uint64
+	log    *logrus.Entry
+	mu     sync.Mutex
+}
+
+type dedupeState struct {
+	Sequence uint64 `json:"last_sequence"`
+}
+
+func newDedupe(dir string, c *config.TopicConf) (*dedupe, error) {
+	if !c.Deduplicate {
+		return nil, nil
+	}
+
+	if dir == "" {
+		return nil, fmt.Errorf("deduplicate requires a state_dir")
+	}
+
+	if c.Workers > 1 || c.Queued {
+		return nil, fmt.Errorf("deduplicate requires a single worker that is not queued")
+	}
+
+	if c.PublishAsync {
+		return nil, fmt.Errorf("deduplicate cannot be used with publish_async")
+	}
+
+	d := &dedupe{
+		file:   filepath.Join(dir, fmt.Sprintf("%s.sequence.json", c.Name)),
+		source: c.SourceID,
+		log:    logrus.WithFields(logrus.Fields{"name": c.Name}),
+	}
+
+	if d.source == "" {
+		d.source = c.SourceStream
+	}
+
+	err := d.load()
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+func (d *dedupe) load() error {
+	content, err := ioutil.ReadFile(d.file)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read sequence state %s: %s", d.file, err)
+	}
+
+	// a file left empty or partly written by a crash only means messages copied
+	// before the crash might be copied again, that is better than not starting
+	state := dedupeState{}
+	err = json.Unmarshal(content, &state)
+	if err != nil {
+		d.log.Warnf("Ignoring sequence state %s that could not be parsed, recently copied messages might be copied again: %s", d.file, err)
+		return nil
+	}
+
+	d.last = state.Sequence
+
+	return nil
+}
+
+// Copied determines if msg was already copied, only redelivered messages can have been
+// copied before so a new message with an earlier sequence means the source was recreated
+// or the subscription was reset and copying starts over from that sequence
+func (d *dedupe) Copied(msg *connector.Msg) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if msg.Sequence > d.last {
+		return false
+	}
+
+	if msg.Redelivered {
+		return true
+	}
+
+	d.log.Warnf("Received message %d that is not after the last copied sequence %d, the source was reset", msg.Sequence, d.last)
+	d.last = 0
+
+	return false
+}
+
+// Record saves the sequence of msg as the last one that was copied
+func (d *dedupe) Record(msg *connector.Msg) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	content, err := json.Marshal(dedupeState{Sequence: msg.Sequence})
+	if err != nil {
+		return err
+	}
+
+	tmpfile, err := ioutil.TempFile(filepath.Dir(d.file), "sequence")
+	if err != nil {
+		return err
+	}
+
+	_, err = tmpfile.Write(content)
+	if err == nil {
+		err = tmpfile.Sync()
+	}
+	if err != nil {
+		tmpfile.Close()
+		os.Remove(tmpfile.Name())
+		return err
+	}
+
+	err = tmpfile.Close()
+	if err != nil {
+		os.Remove(tmpfile.Name())
+		return err
+	}
+
+	err = os.Rename(tmpfile.Name(), d.file)
+	if err != nil {
+		return err
+	}
+
+	// the rename is only durable once the directory is synced
+	err = syncDir(filepath.Dir(d.file))
+	if err != nil {
+		return err
+	}
+
+	d.last = msg.Sequence
+
+	return nil
+}
+
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return f.Sync()
+}
+
+// MsgID is a message id unique to msg on the source used by targets that support de-duplication
+func (d *dedupe) MsgID(msg *connector.Msg) string {
+	return fmt.Sprintf("%s-%d", d.source, msg.Sequence)
+}
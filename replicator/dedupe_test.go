@@ -0,0 +1,86 @@
+package replicator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Dedupe", func() {
+	var (
+		dir  string
+		conf *config.TopicConf
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		conf = &config.TopicConf{Name: "cmdb", SourceID: "dc1", Workers: 1, Deduplicate: true}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should validate the configuration", func() {
+		_, err := newDedupe("", conf)
+		Expect(err).To(MatchError("deduplicate requires a state_dir"))
+
+		conf.Queued = true
+		_, err = newDedupe(dir, conf)
+		Expect(err).To(MatchError("deduplicate requires a single worker that is not queued"))
+
+		conf.Queued = false
+		conf.PublishAsync = true
+		_, err = newDedupe(dir, conf)
+		Expect(err).To(MatchError("deduplicate cannot be used with publish_async"))
+
+		conf.Deduplicate = false
+		d, err := newDedupe(dir, conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d).To(BeNil())
+	})
+
+	It("Should remember copied sequences across restarts", func() {
+		d, err := newDedupe(dir, conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		msg := &connector.Msg{Sequence: 10}
+		Expect(d.Copied(msg)).To(BeFalse())
+		Expect(d.Record(msg)).To(Succeed())
+		Expect(d.Copied(&connector.Msg{Sequence: 10, Redelivered: true})).To(BeTrue())
+		Expect(d.MsgID(msg)).To(Equal("dc1-10"))
+
+		d, err = newDedupe(dir, conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d.Copied(&connector.Msg{Sequence: 9, Redelivered: true})).To(BeTrue())
+		Expect(d.Copied(&connector.Msg{Sequence: 11})).To(BeFalse())
+	})
+
+	It("Should copy new messages with earlier sequences after the source was reset", func() {
+		d, err := newDedupe(dir, conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d.Record(&connector.Msg{Sequence: 10})).To(Succeed())
+
+		Expect(d.Copied(&connector.Msg{Sequence: 10, Redelivered: true})).To(BeTrue())
+		Expect(d.Copied(&connector.Msg{Sequence: 1})).To(BeFalse())
+		Expect(d.Record(&connector.Msg{Sequence: 1})).To(Succeed())
+		Expect(d.Copied(&connector.Msg{Sequence: 2})).To(BeFalse())
+		Expect(d.Copied(&connector.Msg{Sequence: 1, Redelivered: true})).To(BeTrue())
+	})
+
+	It("Should start over when the state cannot be parsed", func() {
+		Expect(ioutil.WriteFile(filepath.Join(dir, "cmdb.sequence.json"), []byte(`{"last_seq`), 0600)).To(Succeed())
+
+		d, err := newDedupe(dir, conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(d.Copied(&connector.Msg{Sequence: 1, Redelivered: true})).To(BeFalse())
+	})
+})
@@ -0,0 +1,167 @@
+package replicator
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/choria-io/go-choria/providers/security"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/envelope"
+)
+
+// crypter encrypts message data before it is published or decrypts data that
+// was encrypted by an upstream replicator, either using a shared key or using
+// the certificate of the replicator that will decrypt the data
+type crypter struct {
+	encrypt bool
+	key     []byte
+	cert    *x509.Certificate
+	pk      *rsa.PrivateKey
+}
+
+func newCrypter(c *config.TopicConf) (*crypter, error) {
+	if c.Encryption == nil {
+		return nil, nil
+	}
+
+	e := c.Encryption
+	cr := &crypter{}
+
+	if e.KeyFile != "" && e.Certificate != "" {
+		return nil, fmt.Errorf("only one of key_file or certificate can be set for encryption")
+	}
+
+	var err error
+
+	switch e.Mode {
+	case "encrypt":
+		cr.encrypt = true
+
+		switch {
+		case e.KeyFile != "":
+			cr.key, err = readKeyFile(e.KeyFile)
+		case e.Certificate != "":
+			cr.cert, err = readCertificate(e.Certificate, c.TargetSecurity())
+		default:
+			err = fmt.Errorf("encryption requires a key_file or certificate")
+		}
+
+	case "decrypt":
+		// messages that cannot be decrypted are never copied, without dead letters they would
+		// be discarded and a wrong or rotated key would silently discard the whole stream
+		if c.DeadLetter == nil {
+			return nil, fmt.Errorf("decryption requires a dead_letter subject to keep messages that cannot be decrypted")
+		}
+
+		if e.KeyFile != "" {
+			cr.key, err = readKeyFile(e.KeyFile)
+		} else {
+			cr.pk, err = privateKey(c)
+		}
+
+	default:
+		err = fmt.Errorf("invalid encryption mode %q, valid modes are encrypt and decrypt", e.Mode)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cr, nil
+}
+
+// readKeyFile reads a hex encoded AES key, it can be created using openssl rand -hex 32
+func readKeyFile(file string) ([]byte, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read encryption key: %s", err)
+	}
+
+	key, err := hex.DecodeString(string(bytes.TrimSpace(content)))
+	if err != nil {
+		return nil, fmt.Errorf("encryption key %s is not hex encoded: %s", file, err)
+	}
+
+	switch len(key) {
+	case 16, 24, 32:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("encryption key %s should be 16, 24 or 32 bytes long", file)
+	}
+}
+
+// readCertificate reads the certificate of the replicator that will decrypt the data,
+// when TLS is configured for the target the certificate has to be signed by its CA
+func readCertificate(file string, provider security.Provider) (*x509.Certificate, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read encryption certificate: %s", err)
+	}
+
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("encryption certificate %s is not PEM encoded", file)
+	}
+
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encryption certificate %s: %s", file, err)
+	}
+
+	if provider != nil {
+		err = provider.VerifyCertificate(content, cert.Subject.CommonName)
+		if err != nil {
+			return nil, fmt.Errorf("could not verify encryption certificate %s: %s", file, err)
+		}
+	}
+
+	return cert, nil
+}
+
+// privateKey is the key of the identity used to connect to the source, encrypted
+// data is read from the source so its identity is the one data is encrypted to
+func privateKey(c *config.TopicConf) (*rsa.PrivateKey, error) {
+	provider := c.SourceSecurity()
+	if provider == nil {
+		return nil, fmt.Errorf("decrypting without a key_file requires a TLS configuration")
+	}
+
+	tlsc, err := provider.TLSConfig()
+	if err != nil {
+		return nil, fmt.Errorf("could not load TLS configuration: %s", err)
+	}
+
+	if len(tlsc.Certificates) == 0 {
+		return nil, fmt.Errorf("no certificate found in the TLS configuration")
+	}
+
+	pk, ok := tlsc.Certificates[0].PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("the TLS configuration does not hold a RSA private key")
+	}
+
+	return pk, nil
+}
+
+// Encrypt encrypts the data in env
+func (c *crypter) Encrypt(env *envelope.V1) error {
+	if c.cert != nil {
+		return env.EncryptForCertificate(c.cert)
+	}
+
+	return env.Encrypt(c.key)
+}
+
+// Decrypt decrypts and decompresses the data in env
+func (c *crypter) Decrypt(env *envelope.V1) error {
+	if c.pk != nil {
+		return env.DecryptWithPrivateKey(c.pk)
+	}
+
+	return env.Decrypt(c.key)
+}
@@ -0,0 +1,179 @@
+package replicator
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/choria-io/stream-replicator/envelope"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Encryption", func() {
+	var (
+		dir     string
+		keyfile string
+		conf    *config.TopicConf
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "")
+		Expect(err).ToNot(HaveOccurred())
+
+		keyfile = filepath.Join(dir, "key")
+		Expect(ioutil.WriteFile(keyfile, []byte("000102030405060708090a0b0c0d0e0f000102030405060708090a0b0c0d0e0f\n"), 0600)).To(Succeed())
+
+		conf = &config.TopicConf{SourceID: "dc1", Name: "cmdb", Encryption: &config.EncryptionConf{Mode: "encrypt", KeyFile: keyfile}}
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should validate the configuration", func() {
+		conf.Encryption.Mode = "scramble"
+		_, err := newCrypter(conf)
+		Expect(err).To(MatchError(`invalid encryption mode "scramble", valid modes are encrypt and decrypt`))
+
+		conf.Encryption.Mode = "encrypt"
+		conf.Encryption.KeyFile = ""
+		_, err = newCrypter(conf)
+		Expect(err).To(MatchError("encryption requires a key_file or certificate"))
+
+		conf.Encryption.Mode = "decrypt"
+		_, err = newCrypter(conf)
+		Expect(err).To(MatchError("decryption requires a dead_letter subject to keep messages that cannot be decrypted"))
+
+		conf.DeadLetter = &config.DeadLetterConf{Subject: "sr.dead"}
+		_, err = newCrypter(conf)
+		Expect(err).To(MatchError("decrypting without a key_file requires a TLS configuration"))
+
+		Expect(ioutil.WriteFile(keyfile, []byte("0001"), 0600)).To(Succeed())
+		conf.Encryption.KeyFile = keyfile
+		_, err = newCrypter(conf)
+		Expect(err).To(MatchError("encryption key " + keyfile + " should be 16, 24 or 32 bytes long"))
+	})
+
+	It("Should encrypt and decrypt messages", func() {
+		msg := &connector.Msg{Subject: "acme.cmdb", Sequence: 10, Data: []byte(`{"hello":"world"}`)}
+
+		w, err := newWrapper(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(conf.Envelope).To(Equal("raw"))
+
+		_, env, err := w.Unwrap(msg)
+		Expect(err).ToNot(HaveOccurred())
+
+		encrypted, err := w.Wrap(env)
+		Expect(err).ToNot(HaveOccurred())
+
+		env, err = envelope.Unwrap(encrypted)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.Encryption).To(Equal(envelope.AESGCMEncryption))
+		Expect(env.Data).ToNot(Equal(msg.Data))
+
+		// a replicator without the key copies the data as is
+		relay, err := newWrapper(&config.TopicConf{SourceID: "dc2", Envelope: "raw"})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, env, err = relay.Unwrap(&connector.Msg{Data: encrypted})
+		Expect(err).ToNot(HaveOccurred())
+		relayed, err := relay.Wrap(env)
+		Expect(err).ToNot(HaveOccurred())
+
+		w, err = newWrapper(&config.TopicConf{SourceID: "dc3", Decompress: true, DeadLetter: &config.DeadLetterConf{Subject: "sr.dead"}, Encryption: &config.EncryptionConf{Mode: "decrypt", KeyFile: keyfile}})
+		Expect(err).ToNot(HaveOccurred())
+
+		m, env, err := w.Unwrap(&connector.Msg{Data: relayed})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Data).To(Equal(msg.Data))
+		Expect(env.Hops).To(Equal([]string{"dc1", "dc2"}))
+		Expect(w.Wrap(env)).To(Equal(msg.Data))
+	})
+
+	It("Should encrypt to certificates using the source and target TLS settings", func() {
+		// writePEM writes der to a file in dir and returns its path
+		writePEM := func(name string, kind string, der []byte) string {
+			file := filepath.Join(dir, name)
+			Expect(ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: kind, Bytes: der}), 0600)).To(Succeed())
+			return file
+		}
+
+		capk, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+
+		catmpl := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: "ca"},
+			NotBefore:             time.Now().Add(-time.Minute),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+
+		cader, err := x509.CreateCertificate(rand.Reader, catmpl, catmpl, &capk.PublicKey, capk)
+		Expect(err).ToNot(HaveOccurred())
+		ca := writePEM("ca.pem", "CERTIFICATE", cader)
+
+		pk, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+
+		tmpl := &x509.Certificate{
+			SerialNumber: big.NewInt(2),
+			Subject:      pkix.Name{CommonName: "dc2.replicator"},
+			NotBefore:    time.Now().Add(-time.Minute),
+			NotAfter:     time.Now().Add(time.Hour),
+			KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth, x509.ExtKeyUsageServerAuth},
+		}
+
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, catmpl, &pk.PublicKey, capk)
+		Expect(err).ToNot(HaveOccurred())
+		cert := writePEM("cert.pem", "CERTIFICATE", der)
+		key := writePEM("key.pem", "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(pk))
+
+		target, err := (&config.TLSConf{Scheme: "file", Identity: "dc1.replicator", CA: ca}).SecurityProvider()
+		Expect(err).ToNot(HaveOccurred())
+
+		source, err := (&config.TLSConf{Scheme: "file", Identity: "dc2.replicator", CA: ca, Cert: cert, Key: key}).SecurityProvider()
+		Expect(err).ToNot(HaveOccurred())
+
+		conf = &config.TopicConf{SourceID: "dc1", Name: "cmdb", TargetSecurityProvider: target, Encryption: &config.EncryptionConf{Mode: "encrypt", Certificate: cert}}
+		w, err := newWrapper(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		msg := &connector.Msg{Subject: "acme.cmdb", Sequence: 10, Data: []byte(`{"hello":"world"}`)}
+		_, env, err := w.Unwrap(msg)
+		Expect(err).ToNot(HaveOccurred())
+
+		encrypted, err := w.Wrap(env)
+		Expect(err).ToNot(HaveOccurred())
+
+		w, err = newWrapper(&config.TopicConf{SourceID: "dc2", SourceSecurityProvider: source, DeadLetter: &config.DeadLetterConf{Subject: "sr.dead"}, Encryption: &config.EncryptionConf{Mode: "decrypt"}})
+		Expect(err).ToNot(HaveOccurred())
+
+		m, _, err := w.Unwrap(&connector.Msg{Data: encrypted})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Data).To(Equal(msg.Data))
+
+		// certificates not signed by the target CA are rejected
+		der, err = x509.CreateCertificate(rand.Reader, tmpl, tmpl, &pk.PublicKey, pk)
+		Expect(err).ToNot(HaveOccurred())
+		conf.Encryption.Certificate = writePEM("self.pem", "CERTIFICATE", der)
+
+		_, err = newWrapper(conf)
+		Expect(err).To(MatchError(HavePrefix("could not verify encryption certificate " + conf.Encryption.Certificate)))
+	})
+})
@@ -0,0 +1,82 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+)
+
+// filter decides if messages should be replicated based on an expression
+type filter struct {
+	program *vm.Program
+	mu      sync.Mutex
+}
+
+func newFilter(c *config.TopicConf) (*filter, error) {
+	f := &filter{}
+
+	if c.Filter == "" {
+		return f, nil
+	}
+
+	var err error
+	f.program, err = expr.Compile(c.Filter, expr.Env(filterEnv(&connector.Msg{})), expr.AsBool())
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %s", c.Filter, err)
+	}
+
+	return f, nil
+}
+
+// filterEnv is the data available to filter expressions, data is the JSON
+// decoded message body and is empty for bodies that are not JSON objects
+func filterEnv(msg *connector.Msg) map[string]interface{} {
+	data := make(map[string]interface{})
+	json.Unmarshal(msg.Data, &data)
+
+	return map[string]interface{}{
+		"data":      data,
+		"subject":   msg.Subject,
+		"sequence":  msg.Sequence,
+		"timestamp": msg.Timestamp.Unix(),
+	}
+}
+
+// Update replaces the expression with the one from other while running
+func (f *filter) Update(other *filter) {
+	other.mu.Lock()
+	program := other.program
+	other.mu.Unlock()
+
+	f.mu.Lock()
+	f.program = program
+	f.mu.Unlock()
+}
+
+// Match determines if msg should be replicated, without an expression all messages match
+func (f *filter) Match(msg *connector.Msg) (bool, error) {
+	f.mu.Lock()
+	program := f.program
+	f.mu.Unlock()
+
+	if program == nil {
+		return true, nil
+	}
+
+	res, err := expr.Run(program, filterEnv(msg))
+	if err != nil {
+		return false, fmt.Errorf("could not evaluate filter: %s", err)
+	}
+
+	match, ok := res.(bool)
+	if !ok {
+		return false, fmt.Errorf("filter returned %T instead of a boolean", res)
+	}
+
+	return match, nil
+}
@@ -0,0 +1,87 @@
+package replicator
+
+import (
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Filter", func() {
+	var (
+		conf *config.TopicConf
+		msg  *connector.Msg
+	)
+
+	BeforeEach(func() {
+		conf = &config.TopicConf{Topic: "acme.cmdb"}
+		msg = &connector.Msg{
+			Subject:   "acme.cmdb",
+			Sequence:  10,
+			Timestamp: time.Now(),
+			Data:      []byte(`{"sender":"node1.example.net","facts":{"country":"mt"}}`),
+		}
+	})
+
+	It("Should match all messages without an expression", func() {
+		f, err := newFilter(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Match(msg)).To(BeTrue())
+	})
+
+	It("Should fail for invalid expressions", func() {
+		conf.Filter = "data.sender =="
+		_, err := newFilter(conf)
+		Expect(err).To(HaveOccurred())
+
+		conf.Filter = "subject"
+		_, err = newFilter(conf)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should match against the message body and metadata", func() {
+		conf.Filter = `data.facts.country == "mt" && subject == "acme.cmdb" && sequence > 5`
+		f, err := newFilter(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Match(msg)).To(BeTrue())
+
+		msg.Sequence = 1
+		Expect(f.Match(msg)).To(BeFalse())
+	})
+
+	It("Should update the expression", func() {
+		f, err := newFilter(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		conf.Filter = `sequence > 20`
+		other, err := newFilter(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		f.Update(other)
+		Expect(f.Match(msg)).To(BeFalse())
+	})
+
+	It("Should handle bodies that are not JSON", func() {
+		conf.Filter = `data.sender == "node1.example.net"`
+		f, err := newFilter(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		msg.Data = []byte("not json")
+		Expect(f.Match(msg)).To(BeFalse())
+	})
+
+	It("Should fail to compare fields that are not set", func() {
+		conf.Filter = `data.size > 10`
+		f, err := newFilter(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = f.Match(msg)
+		Expect(err).To(MatchError(HavePrefix("could not evaluate filter: ")))
+
+		msg.Data = []byte("not json")
+		_, err = f.Match(msg)
+		Expect(err).To(MatchError(HavePrefix("could not evaluate filter: ")))
+	})
+})
@@ -0,0 +1,160 @@
+package replicator
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+)
+
+// healthChecks are the thresholds beyond which a copier is unhealthy
+type healthChecks struct {
+	disconnected   time.Duration
+	stalled        time.Duration
+	advisorFailing time.Duration
+}
+
+// healthResponse is the body of the /healthz and /readyz responses
+type healthResponse struct {
+	OK       bool     `json:"ok"`
+	Problems []string `json:"problems"`
+}
+
+func newHealthChecks(c *config.HealthConf) (*healthChecks, error) {
+	h := &healthChecks{
+		disconnected:   time.Minute,
+		stalled:        5 * time.Minute,
+		advisorFailing: 5 * time.Minute,
+	}
+
+	if c == nil {
+		return h, nil
+	}
+
+	for _, d := range []struct {
+		name  string
+		value string
+		dur   *time.Duration
+	}{
+		{"disconnected", c.Disconnected, &h.disconnected},
+		{"stalled", c.Stalled, &h.stalled},
+		{"advisor_failing", c.AdvisorFailing, &h.advisorFailing},
+	} {
+		if d.value == "" {
+			continue
+		}
+
+		dur, err := time.ParseDuration(d.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid health %s: %s", d.name, err)
+		}
+
+		if dur <= 0 {
+			return nil, fmt.Errorf("health %s should be positive", d.name)
+		}
+
+		*d.dur = dur
+	}
+
+	return h, nil
+}
+
+// Health reports problems that make the copier unhealthy, it is healthy when none are returned
+func (c *Copier) Health(h *healthChecks) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	problems := []string{}
+
+	var last time.Time
+	for _, w := range c.workers {
+		problems = append(problems, w.health(h.disconnected)...)
+
+		acked := time.Unix(0, atomic.LoadInt64(&w.lastAcked))
+		if acked.After(last) {
+			last = acked
+		}
+	}
+
+	if c.lag != nil && !c.paused && !c.started.IsZero() {
+		if c.started.After(last) {
+			last = c.started
+		}
+
+		lag := c.lag.Status()
+		if lag.Error == "" && lag.LagMessages > 0 && time.Since(last) > h.stalled {
+			problems = append(problems, fmt.Sprintf("no messages were copied for %v while %d messages are pending", time.Since(last).Round(time.Second), lag.LagMessages))
+		}
+	}
+
+	if c.advisor != nil {
+		state := c.advisor.State()
+		if state.FailingSince != nil && time.Since(*state.FailingSince) > h.advisorFailing {
+			problems = append(problems, fmt.Sprintf("advisories could not be published for %v", time.Since(*state.FailingSince).Round(time.Second)))
+		}
+	}
+
+	return problems
+}
+
+// Ready reports problems that prevent the copier from replicating, it is ready when none are returned
+func (c *Copier) Ready() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ctx.Err() != nil {
+		return []string{"replication has stopped"}
+	}
+
+	if len(c.workers) == 0 {
+		return []string{"replication has not started"}
+	}
+
+	problems := []string{}
+	for _, w := range c.workers {
+		problems = append(problems, w.ready()...)
+	}
+
+	return problems
+}
+
+// SetupHealth serves health checks on /healthz and readiness checks on /readyz of the
+// monitoring port, both respond with 503 Service Unavailable when any copier has problems
+func SetupHealth(copiers Copiers, conf *config.HealthConf) error {
+	h, err := newHealthChecks(conf)
+	if err != nil {
+		return err
+	}
+
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		respondHealth(w, copiers, func(c *Copier) []string { return c.Health(h) })
+	})
+
+	http.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		respondHealth(w, copiers, func(c *Copier) []string { return c.Ready() })
+	})
+
+	return nil
+}
+
+func respondHealth(w http.ResponseWriter, copiers Copiers, check func(c *Copier) []string) {
+	res := healthResponse{Problems: []string{}}
+
+	for _, c := range copiers() {
+		for _, p := range check(c) {
+			res.Problems = append(res.Problems, fmt.Sprintf("%s: %s", c.config.Name, p))
+		}
+	}
+
+	res.OK = len(res.Problems) == 0
+
+	w.Header().Set("Content-Type", "application/json")
+	if !res.OK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(res)
+}
@@ -0,0 +1,129 @@
+package replicator
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Health", func() {
+	var (
+		c      *Copier
+		from   *stubStream
+		to     *stubStream
+		checks *healthChecks
+	)
+
+	BeforeEach(func() {
+		c = &Copier{}
+		Expect(c.Setup("test", &config.TopicConf{
+			Topic:         "acme.cmdb",
+			SourceID:      "dc1",
+			SourceMonitor: "http://localhost:8222",
+			TargetURL:     "nats://localhost:4222",
+			TargetID:      "dc2",
+			Name:          "cmdb",
+		})).To(Succeed())
+
+		from = &stubStream{}
+		to = &stubStream{}
+
+		w := newWorker(0, c)
+		w.from = from
+		w.targets = []*target{{name: "dc2", conn: to}}
+
+		c.workers = []*worker{w}
+		c.started = time.Now()
+
+		var err error
+		checks, err = newHealthChecks(&config.HealthConf{Disconnected: "1m", Stalled: "5m"})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Describe("newHealthChecks", func() {
+		It("Should set defaults and validate durations", func() {
+			h, err := newHealthChecks(&config.HealthConf{Stalled: "10m"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(h.disconnected).To(Equal(time.Minute))
+			Expect(h.stalled).To(Equal(10 * time.Minute))
+			Expect(h.advisorFailing).To(Equal(5 * time.Minute))
+
+			_, err = newHealthChecks(&config.HealthConf{Disconnected: "soon"})
+			Expect(err).To(MatchError(`invalid health disconnected: time: invalid duration "soon"`))
+
+			_, err = newHealthChecks(&config.HealthConf{AdvisorFailing: "-1s"})
+			Expect(err).To(MatchError("health advisor_failing should be positive"))
+		})
+	})
+
+	Describe("Health", func() {
+		It("Should be healthy when connected", func() {
+			Expect(c.Health(checks)).To(BeEmpty())
+		})
+
+		It("Should detect long disconnections", func() {
+			to.state = connector.StateReconnecting
+			to.down = 30 * time.Second
+			Expect(c.Health(checks)).To(BeEmpty())
+
+			to.down = 2 * time.Minute
+			Expect(c.Health(checks)).To(Equal([]string{"cmdb_0 target dc2 has been reconnecting for 2m0s"}))
+		})
+
+		It("Should detect stalled replication", func() {
+			c.lag.status.LagMessages = 10
+			Expect(c.Health(checks)).To(BeEmpty())
+
+			c.started = time.Now().Add(-10 * time.Minute)
+			Expect(c.Health(checks)).To(Equal([]string{"no messages were copied for 10m0s while 10 messages are pending"}))
+
+			c.workers[0].ack(&connector.Msg{Sequence: 1})
+			Expect(c.Health(checks)).To(BeEmpty())
+
+			c.started = time.Now().Add(-10 * time.Minute)
+			c.workers[0].lastAcked = 0
+			c.paused = true
+			Expect(c.Health(checks)).To(BeEmpty())
+		})
+	})
+
+	Describe("Ready", func() {
+		It("Should require all connections to be connected", func() {
+			Expect(c.Ready()).To(BeEmpty())
+
+			from.state = connector.StateConnecting
+			Expect(c.Ready()).To(Equal([]string{"cmdb_0 source is connecting"}))
+
+			c.cancel()
+			Expect(c.Ready()).To(Equal([]string{"replication has stopped"}))
+		})
+
+		It("Should not be ready before starting", func() {
+			c.workers = nil
+			Expect(c.Ready()).To(Equal([]string{"replication has not started"}))
+		})
+	})
+
+	Describe("respondHealth", func() {
+		It("Should respond with problems", func() {
+			rec := httptest.NewRecorder()
+			respondHealth(rec, func() []*Copier { return []*Copier{c} }, func(c *Copier) []string { return []string{"broken"} })
+			Expect(rec.Code).To(Equal(http.StatusServiceUnavailable))
+
+			res := healthResponse{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &res)).To(Succeed())
+			Expect(res).To(Equal(healthResponse{Problems: []string{"cmdb: broken"}}))
+
+			rec = httptest.NewRecorder()
+			respondHealth(rec, func() []*Copier { return []*Copier{c} }, func(c *Copier) []string { return nil })
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Body.String()).To(MatchJSON(`{"ok":true,"problems":[]}`))
+		})
+	})
+})
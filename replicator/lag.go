@@ -0,0 +1,215 @@
+package replicator
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/sirupsen/logrus"
+)
+
+// LagStatus is how far the replicator is behind the source channel
+type LagStatus struct {
+	Channel      string    `json:"channel"`
+	LastSequence uint64    `json:"last_sequence"`
+	LastSent     uint64    `json:"last_sent"`
+	Pending      int       `json:"pending"`
+	LagMessages  uint64    `json:"lag_messages"`
+	LagSeconds   float64   `json:"lag_seconds"`
+	LastCopied   time.Time `json:"last_copied"`
+	Updated      time.Time `json:"updated"`
+	Error        string    `json:"error,omitempty"`
+}
+
+// channelz and subscriptionz are the parts of the NATS Streaming monitoring
+// channelsz response that are used to determine the lag
+type channelz struct {
+	Name          string           `json:"name"`
+	LastSeq       uint64           `json:"last_seq"`
+	Subscriptions []*subscriptionz `json:"subscriptions"`
+}
+
+type subscriptionz struct {
+	DurableName  string `json:"durable_name"`
+	QueueName    string `json:"queue_name"`
+	LastSent     uint64 `json:"last_sent"`
+	PendingCount int    `json:"pending_count"`
+}
+
+// lagMonitor polls the NATS Streaming monitoring port for the state of the
+// source channel and compares it to the state of our durable subscription
+type lagMonitor struct {
+	url      string
+	channel  string
+	durable  string
+	name     string
+	interval time.Duration
+	client   *http.Client
+	status   LagStatus
+	mu       sync.Mutex
+	log      *logrus.Entry
+}
+
+func newLagMonitor(c *config.TopicConf) (*lagMonitor, error) {
+	if c.SourceMonitor == "" {
+		return nil, nil
+	}
+
+	if c.SourceJetStream() {
+		return nil, fmt.Errorf("source_monitor_url is only supported for NATS Streaming sources")
+	}
+
+	m := &lagMonitor{
+		url:      strings.TrimSuffix(c.SourceMonitor, "/"),
+		channel:  c.Topic,
+		durable:  c.Name,
+		name:     c.Name,
+		interval: 30 * time.Second,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		status:   LagStatus{Channel: c.Topic},
+		log:      logrus.WithFields(logrus.Fields{"topic": c.Topic, "monitor": c.SourceMonitor}),
+	}
+
+	if c.LagInterval != "" {
+		var err error
+		m.interval, err = time.ParseDuration(c.LagInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid lag_interval: %s", err)
+		}
+
+		if m.interval < time.Second {
+			return nil, fmt.Errorf("lag_interval should be at least 1s")
+		}
+	}
+
+	return m, nil
+}
+
+// Copied records the time the last copied message was received by the source
+func (m *lagMonitor) Copied(msg *connector.Msg) {
+	m.mu.Lock()
+	if msg.Timestamp.After(m.status.LastCopied) {
+		m.status.LastCopied = msg.Timestamp
+	}
+	m.mu.Unlock()
+}
+
+// Status is the most recently determined lag
+func (m *lagMonitor) Status() LagStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status
+}
+
+func (m *lagMonitor) Monitor(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		err := m.update(ctx)
+		if err != nil {
+			m.log.Warnf("Could not determine replication lag: %s", err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (m *lagMonitor) update(ctx context.Context) error {
+	ch, err := m.channelz(ctx)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status.Updated = time.Now()
+
+	if err != nil {
+		m.status.Error = err.Error()
+		return err
+	}
+
+	m.status.Error = ""
+	m.status.LastSequence = ch.LastSeq
+	m.status.LastSent = 0
+	m.status.Pending = 0
+
+	found := false
+	for _, sub := range ch.Subscriptions {
+		// durable queue subscriptions are named durable:group
+		if sub.DurableName != m.durable && !strings.HasPrefix(sub.QueueName, m.durable+":") {
+			continue
+		}
+
+		found = true
+		m.status.Pending += sub.PendingCount
+		if sub.LastSent > m.status.LastSent {
+			m.status.LastSent = sub.LastSent
+		}
+	}
+
+	if !found {
+		err = fmt.Errorf("no subscription found for durable %s", m.durable)
+		m.status.Error = err.Error()
+		return err
+	}
+
+	m.status.LagMessages = uint64(m.status.Pending)
+	if ch.LastSeq > m.status.LastSent {
+		m.status.LagMessages += ch.LastSeq - m.status.LastSent
+	}
+
+	m.status.LagSeconds = 0
+	if m.status.LagMessages > 0 && !m.status.LastCopied.IsZero() {
+		m.status.LagSeconds = time.Since(m.status.LastCopied).Seconds()
+	}
+
+	sourceSequenceGauge.WithLabelValues(m.name).Set(float64(m.status.LastSequence))
+	pendingGauge.WithLabelValues(m.name).Set(float64(m.status.Pending))
+	lagMsgsGauge.WithLabelValues(m.name).Set(float64(m.status.LagMessages))
+	lagSecondsGauge.WithLabelValues(m.name).Set(m.status.LagSeconds)
+
+	return nil
+}
+
+func (m *lagMonitor) channelz(ctx context.Context) (*channelz, error) {
+	q := url.Values{}
+	q.Set("channel", m.channel)
+	q.Set("subs", "1")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/streaming/channelsz?%s", m.url, q.Encode()), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("monitoring request failed: %s", resp.Status)
+	}
+
+	ch := &channelz{}
+	err = json.NewDecoder(resp.Body).Decode(ch)
+	if err != nil {
+		return nil, fmt.Errorf("invalid monitoring response: %s", err)
+	}
+
+	return ch, nil
+}
@@ -0,0 +1,87 @@
+package replicator
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lag Monitor", func() {
+	var (
+		srv  *httptest.Server
+		conf *config.TopicConf
+		body string
+	)
+
+	BeforeEach(func() {
+		body = `{"name":"acme.cmdb","last_seq":100,"subscriptions":[{"durable_name":"other","last_sent":10,"pending_count":5},{"durable_name":"cmdb","last_sent":90,"pending_count":5}]}`
+
+		srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.URL.Path).To(Equal("/streaming/channelsz"))
+			Expect(r.URL.Query().Get("channel")).To(Equal("acme.cmdb"))
+			Expect(r.URL.Query().Get("subs")).To(Equal("1"))
+			w.Write([]byte(body))
+		}))
+
+		conf = &config.TopicConf{Topic: "acme.cmdb", Name: "cmdb", SourceMonitor: srv.URL + "/"}
+	})
+
+	AfterEach(func() {
+		srv.Close()
+	})
+
+	It("Should validate the configuration", func() {
+		conf.LagInterval = "10ms"
+		_, err := newLagMonitor(conf)
+		Expect(err).To(MatchError("lag_interval should be at least 1s"))
+
+		conf.LagInterval = ""
+		conf.SourceType = config.JetStreamType
+		_, err = newLagMonitor(conf)
+		Expect(err).To(MatchError("source_monitor_url is only supported for NATS Streaming sources"))
+	})
+
+	It("Should determine the lag of the durable", func() {
+		m, err := newLagMonitor(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		m.Copied(&connector.Msg{Timestamp: time.Now().Add(-time.Minute)})
+
+		Expect(m.update(context.Background())).To(Succeed())
+
+		status := m.Status()
+		Expect(status.Error).To(BeEmpty())
+		Expect(status.LastSequence).To(Equal(uint64(100)))
+		Expect(status.LastSent).To(Equal(uint64(90)))
+		Expect(status.Pending).To(Equal(5))
+		Expect(status.LagMessages).To(Equal(uint64(15)))
+		Expect(status.LagSeconds).To(BeNumerically("~", 60, 5))
+	})
+
+	It("Should support queue subscriptions", func() {
+		body = `{"name":"acme.cmdb","last_seq":100,"subscriptions":[{"queue_name":"cmdb:grp","last_sent":100,"pending_count":0}]}`
+
+		m, err := newLagMonitor(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.update(context.Background())).To(Succeed())
+		Expect(m.Status().LagMessages).To(BeZero())
+		Expect(m.Status().LagSeconds).To(BeZero())
+	})
+
+	It("Should report missing subscriptions", func() {
+		conf.Name = "missing"
+
+		m, err := newLagMonitor(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		Expect(m.update(context.Background())).To(MatchError("no subscription found for durable missing"))
+		Expect(m.Status().Error).To(Equal("no subscription found for durable missing"))
+	})
+})
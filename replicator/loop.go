@@ -0,0 +1,48 @@
+package replicator
+
+import (
+	"fmt"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/envelope"
+)
+
+// loopDetector prevents messages from being copied back to clusters they already
+// passed through when a topic is replicated in both directions, it relies on copied
+// messages being wrapped in an envelope that records every cluster they were copied from
+type loopDetector struct {
+	targets []string
+}
+
+func newLoopDetector(c *config.TopicConf) (*loopDetector, error) {
+	if !c.Bidirectional {
+		return nil, nil
+	}
+
+	if c.SourceID == "" {
+		return nil, fmt.Errorf("bidirectional replication requires a source_cluster_id")
+	}
+
+	l := &loopDetector{}
+
+	for _, t := range c.Targets {
+		if t.ID == "" {
+			return nil, fmt.Errorf("bidirectional replication requires a cluster_id for target %s", t.Name)
+		}
+
+		l.targets = append(l.targets, t.ID)
+	}
+
+	return l, nil
+}
+
+// Loops determines if copying env would send it to a cluster it already passed through
+func (l *loopDetector) Loops(env *envelope.V1) bool {
+	for _, t := range l.targets {
+		if env.Visited(t) {
+			return true
+		}
+	}
+
+	return false
+}
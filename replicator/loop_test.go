@@ -0,0 +1,60 @@
+package replicator
+
+import (
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/envelope"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Loop Detector", func() {
+	var (
+		conf *config.TopicConf
+		l    *loopDetector
+	)
+
+	BeforeEach(func() {
+		conf = &config.TopicConf{
+			SourceID:      "dc1",
+			Bidirectional: true,
+			Targets:       []*config.TargetConf{{Name: "dc2", ID: "dc2"}},
+		}
+
+		var err error
+		l, err = newLoopDetector(conf)
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Should only be created for bidirectional topics", func() {
+		conf.Bidirectional = false
+		l, err := newLoopDetector(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(l).To(BeNil())
+	})
+
+	It("Should require cluster ids", func() {
+		conf.Targets[0].ID = ""
+		_, err := newLoopDetector(conf)
+		Expect(err).To(MatchError("bidirectional replication requires a cluster_id for target dc2"))
+	})
+
+	It("Should allow messages that did not pass through a target", func() {
+		env := envelope.New("dc1", nil)
+		Expect(l.Loops(env)).To(BeFalse())
+
+		env = envelope.New("dc3", nil)
+		env.AddHop("dc3")
+		Expect(l.Loops(env)).To(BeFalse())
+	})
+
+	It("Should detect messages copied from a target", func() {
+		env := envelope.New("dc2", nil)
+		env.AddHop("dc2")
+		Expect(l.Loops(env)).To(BeTrue())
+
+		env = envelope.New("dc3", nil)
+		env.AddHop("dc3")
+		env.AddHop("dc2")
+		Expect(l.Loops(env)).To(BeTrue())
+	})
+})
@@ -0,0 +1,88 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync/atomic"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/tidwall/gjson"
+)
+
+// partitioner dispatches messages received by a single subscriber to the workers
+// of a copier based on the hash of a key in the message, messages with the same
+// key are always copied by the same worker and so remain in order
+type partitioner struct {
+	// busy is the number of messages being copied by all workers, accessed atomically
+	busy int64
+
+	key    string
+	queues []chan *connector.Msg
+}
+
+func newPartitioner(c *config.TopicConf, buffer int) (*partitioner, error) {
+	if !c.Partitioned {
+		return nil, nil
+	}
+
+	p := &partitioner{
+		key:    c.PartitionKey,
+		queues: make([]chan *connector.Msg, c.Workers),
+	}
+
+	if p.key == "" {
+		p.key = c.Inspect
+	}
+
+	if p.key == "" {
+		return nil, fmt.Errorf("partitioned replication requires a partition_key or inspect key")
+	}
+
+	for i := range p.queues {
+		p.queues[i] = make(chan *connector.Msg, buffer)
+	}
+
+	return p, nil
+}
+
+// Partition is the worker that should copy msg, messages without the key all go to the first worker
+func (p *partitioner) Partition(msg *connector.Msg) int {
+	res := gjson.GetBytes(msg.Data, p.key)
+	if !res.Exists() {
+		return 0
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(res.String()))
+
+	return int(h.Sum32() % uint32(len(p.queues)))
+}
+
+// Dispatch queues msg for the worker that should copy it, it blocks while that worker is busy,
+// dispatched messages are counted as busy till the worker tracks them as copied
+func (p *partitioner) Dispatch(ctx context.Context, msg *connector.Msg) {
+	p.Track(1)
+
+	select {
+	case p.queues[p.Partition(msg)] <- msg:
+	case <-ctx.Done():
+		p.Track(-1)
+	}
+}
+
+// Queue is the queue of messages for worker i
+func (p *partitioner) Queue(i int) chan *connector.Msg {
+	return p.queues[i]
+}
+
+// Track adjusts the count of messages dispatched to the workers and not yet copied
+func (p *partitioner) Track(delta int64) {
+	atomic.AddInt64(&p.busy, delta)
+}
+
+// Busy is the number of messages dispatched to the workers and not yet copied
+func (p *partitioner) Busy() int64 {
+	return atomic.LoadInt64(&p.busy)
+}
@@ -0,0 +1,61 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Partitioner", func() {
+	var conf *config.TopicConf
+
+	BeforeEach(func() {
+		conf = &config.TopicConf{Workers: 4, Partitioned: true, Inspect: "sender"}
+	})
+
+	It("Should only be created in partitioned mode", func() {
+		conf.Partitioned = false
+		p, err := newPartitioner(conf, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p).To(BeNil())
+	})
+
+	It("Should require a key", func() {
+		conf.Inspect = ""
+		_, err := newPartitioner(conf, 10)
+		Expect(err).To(MatchError("partitioned replication requires a partition_key or inspect key"))
+
+		conf.PartitionKey = "identity"
+		p, err := newPartitioner(conf, 10)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(p.key).To(Equal("identity"))
+	})
+
+	It("Should consistently dispatch messages with the same key to the same worker", func() {
+		p, err := newPartitioner(conf, 100)
+		Expect(err).ToNot(HaveOccurred())
+
+		seen := make(map[int]bool)
+
+		for i := 0; i < 20; i++ {
+			sender := fmt.Sprintf("node%d.example.net", i)
+			msg := &connector.Msg{Data: []byte(fmt.Sprintf(`{"sender":%q}`, sender))}
+
+			part := p.Partition(msg)
+			Expect(part).To(BeNumerically("<", 4))
+			Expect(p.Partition(&connector.Msg{Data: []byte(fmt.Sprintf(`{"sender":%q,"other":1}`, sender))})).To(Equal(part))
+			seen[part] = true
+
+			p.Dispatch(context.Background(), msg)
+			Expect(p.Queue(part)).To(Receive(Equal(msg)))
+		}
+
+		Expect(seen).To(HaveLen(4))
+		Expect(p.Busy()).To(BeNumerically("==", 20))
+		Expect(p.Partition(&connector.Msg{Data: []byte("{}")})).To(Equal(0))
+	})
+})
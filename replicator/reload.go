@@ -0,0 +1,102 @@
+package replicator
+
+import (
+	"strings"
+
+	"github.com/choria-io/stream-replicator/config"
+)
+
+// Reload applies a new configuration to a running copier, changes to workers, filter,
+// rate_limit, age and advisory are applied without reconnecting while any other change
+// requires the copier to be restarted which is indicated by restart being true
+func (c *Copier) Reload(topic *config.TopicConf) (restart bool, err error) {
+	next := &Copier{}
+	err = next.Setup(c.name, topic)
+	if err != nil {
+		return false, err
+	}
+	next.cancel()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	changes := c.config.Changes(next.config)
+	if len(changes) == 0 {
+		c.Log.Infof("No configuration changes to apply")
+		return false, nil
+	}
+
+	for _, change := range changes {
+		if !c.reloadable(change, next.config) {
+			c.Log.Warnf("Restarting to apply changes to %s, %s cannot be changed while running", strings.Join(changes, ", "), change)
+			return true, nil
+		}
+	}
+
+	changed := make(map[string]bool)
+	for _, change := range changes {
+		changed[change] = true
+	}
+
+	// the advisor and limiter parse the durations, the advisor checks both so it
+	// goes first and invalid durations do not leave changes partially applied
+	if (changed["age"] || changed["advisory"]) && c.config.Advisory != nil {
+		err = c.advisor.Update(next.config)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if changed["age"] {
+		err = c.limiter.Update(next.config)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if changed["rate_limit"] {
+		err = c.throttle.Configure(next.config.RateLimit)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if changed["filter"] {
+		c.filter.Update(next.filter)
+	}
+
+	if changed["workers"] && c.runctx != nil {
+		c.scale(next.config.Workers)
+	}
+
+	c.config.Filter = next.config.Filter
+	c.config.RateLimit = next.config.RateLimit
+	c.config.MinAge = next.config.MinAge
+	c.config.Advisory = next.config.Advisory
+	c.config.Workers = next.config.Workers
+
+	c.Log.Infof("Applied changes to %s without reconnecting", strings.Join(changes, ", "))
+
+	return false, nil
+}
+
+// reloadable determines if a setting can be changed to the value in next while running
+func (c *Copier) reloadable(setting string, next *config.TopicConf) bool {
+	switch setting {
+	case "filter", "rate_limit":
+		return true
+
+	case "workers":
+		// messages are assigned to partitions by the number of workers
+		return c.parts == nil
+
+	case "age":
+		return c.limiter != nil && next.Inspect != "" && next.MinAge != ""
+
+	case "advisory":
+		return c.limiter != nil && c.config.Advisory != nil && next.Advisory != nil && c.config.Advisory.Cluster == next.Advisory.Cluster
+
+	default:
+		return false
+	}
+}
@@ -0,0 +1,93 @@
+package replicator
+
+import (
+	"context"
+
+	"github.com/choria-io/stream-replicator/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Reload", func() {
+	var (
+		c    *Copier
+		conf func() *config.TopicConf
+	)
+
+	BeforeEach(func() {
+		conf = func() *config.TopicConf {
+			return &config.TopicConf{
+				Topic:     "acme.cmdb",
+				SourceID:  "dc1",
+				TargetURL: "nats://localhost:4222",
+				TargetID:  "dc2",
+				Name:      "cmdb",
+				Workers:   3,
+			}
+		}
+
+		c = &Copier{}
+		Expect(c.Setup("test", conf())).To(Succeed())
+	})
+
+	It("Should do nothing without changes", func() {
+		Expect(c.Reload(conf())).To(BeFalse())
+	})
+
+	It("Should fail for invalid configurations", func() {
+		next := conf()
+		next.Topic = ""
+
+		_, err := c.Reload(next)
+		Expect(err).To(MatchError("a topic is required"))
+	})
+
+	It("Should apply filters and rate limits while running", func() {
+		next := conf()
+		next.Filter = "sequence > 10"
+		next.RateLimit = &config.RateLimitConf{Messages: 10}
+
+		Expect(c.Reload(next)).To(BeFalse())
+		Expect(c.config.Filter).To(Equal("sequence > 10"))
+		Expect(c.filter.program).ToNot(BeNil())
+		Expect(c.config.RateLimit.Messages).To(Equal(10.0))
+		Expect(c.throttle.msgs.Limit()).To(BeNumerically("==", 10))
+	})
+
+	It("Should scale workers while running", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		c.runctx = ctx
+		stopped := 0
+		for i := 0; i < 3; i++ {
+			w := newWorker(i, c)
+			w.cancel = func() { stopped++ }
+			c.workers = append(c.workers, w)
+		}
+
+		next := conf()
+		next.Workers = 2
+
+		Expect(c.Reload(next)).To(BeFalse())
+		Expect(c.workers).To(HaveLen(2))
+		Expect(c.config.Workers).To(Equal(2))
+		Expect(stopped).To(Equal(1))
+	})
+
+	It("Should restart for changes that cannot be applied while running", func() {
+		next := conf()
+		next.SourceURL = "nats://other:4222"
+		Expect(c.Reload(next)).To(BeTrue())
+		Expect(c.config.SourceURL).To(Equal("nats://localhost:4222"))
+
+		next = conf()
+		next.Workers = 1
+		Expect(c.Reload(next)).To(BeTrue())
+
+		next = conf()
+		next.Inspect = "sender"
+		next.MinAge = "1h"
+		Expect(c.Reload(next)).To(BeTrue())
+	})
+})
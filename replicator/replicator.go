@@ -2,13 +2,16 @@ package replicator
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/choria-io/stream-replicator/advisor"
 	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
 	"github.com/choria-io/stream-replicator/limiter"
 	"github.com/choria-io/stream-replicator/limiter/memory"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -17,15 +20,43 @@ import (
 
 // Copier is a single instance of a topic replicator
 type Copier struct {
-	config *config.TopicConf
-	tls    bool
-	Log    *logrus.Entry
-	ctx    context.Context
-	cancel func()
+	name     string
+	config   *config.TopicConf
+	tls      bool
+	Log      *logrus.Entry
+	ctx      context.Context
+	cancel   func()
+	limiter  *limiter.Limiter
+	advisor  *advisor.Advisor
+	subject  *subjectRewriter
+	filter   *filter
+	wrapper  *wrapper
+	loop     *loopDetector
+	dedupe   *dedupe
+	throttle *throttle
+	parts    *partitioner
+	lag      *lagMonitor
+	subOpts  connector.SubscribeOptions
+	failures *failureTracker
+
+	drainTimeout time.Duration
+
+	// pmu serializes Pause and Resume, it is held while workers drain and subscribe
+	// which can take long so the admin api and health checks only take mu
+	pmu sync.Mutex
+
+	// mu protects the state the admin api accesses while running
+	mu      sync.Mutex
+	workers []*worker
+	paused  bool
+	started time.Time
+	runctx  context.Context
+	running *sync.WaitGroup
 }
 
 // Setup validates the configuration of the copier and sets defaults where possible
 func (c *Copier) Setup(name string, topic *config.TopicConf) error {
+	c.name = name
 	c.config = topic
 	c.tls = config.TLS() || topic.TLS()
 
@@ -37,16 +68,21 @@ func (c *Copier) Setup(name string, topic *config.TopicConf) error {
 		c.config.SourceURL = "nats://localhost:4222"
 	}
 
-	if c.config.SourceID == "" {
+	if c.config.SourceID == "" && !c.config.SourceJetStream() {
 		return fmt.Errorf("a from cluster id is required")
 	}
 
-	if c.config.TargetURL == "" {
-		return fmt.Errorf("a destination URL is required")
+	err := c.setupTargets()
+	if err != nil {
+		return err
 	}
 
-	if c.config.TargetID == "" {
-		return fmt.Errorf("a target cluster id is required")
+	if c.config.SourceType != "" && c.config.SourceType != config.StreamingType && c.config.SourceType != config.JetStreamType {
+		return fmt.Errorf("invalid stream type %q, valid types are %s and %s", c.config.SourceType, config.StreamingType, config.JetStreamType)
+	}
+
+	if c.config.SourceJetStream() && c.config.SourceStream == "" {
+		return fmt.Errorf("a source stream is required when replicating from JetStream")
 	}
 
 	if c.config.Workers == 0 {
@@ -57,7 +93,24 @@ func (c *Copier) Setup(name string, topic *config.TopicConf) error {
 		c.config.Name = fmt.Sprintf("%s_%s_stream_replicator", name, strings.Replace(c.config.Topic, ".", "_", -1))
 	}
 
-	if c.config.Workers > 1 {
+	if c.config.Partitioned {
+		if c.config.Queued {
+			return fmt.Errorf("partitioned replication cannot be queued")
+		}
+
+		if c.config.PublishAsync {
+			return fmt.Errorf("partitioned replication cannot be combined with publish_async")
+		}
+	}
+
+	// the limiter records values as processed once publishing returns, asynchronous
+	// publishes that fail later would be skipped as already seen when redelivered
+	if c.config.PublishAsync && c.config.Inspect != "" && c.config.MinAge != "" {
+		return fmt.Errorf("publish_async cannot be combined with inspect and age")
+	}
+
+	// partitioned copiers have a single subscriber that dispatches to all workers
+	if c.config.Workers > 1 && !c.config.Partitioned {
 		c.config.Queued = true
 	}
 
@@ -65,6 +118,91 @@ func (c *Copier) Setup(name string, topic *config.TopicConf) error {
 		c.config.QueueGroup = fmt.Sprintf("%s_stream_replicator_grp", strings.Replace(c.config.Topic, ".", "_", -1))
 	}
 
+	c.subject, err = newSubjectRewriter(c.config)
+	if err != nil {
+		return err
+	}
+
+	c.filter, err = newFilter(c.config)
+	if err != nil {
+		return err
+	}
+
+	c.wrapper, err = newWrapper(c.config)
+	if err != nil {
+		return err
+	}
+
+	c.loop, err = newLoopDetector(c.config)
+	if err != nil {
+		return err
+	}
+
+	c.dedupe, err = newDedupe(config.StateDirectory(), c.config)
+	if err != nil {
+		return err
+	}
+
+	c.throttle, err = newThrottle(c.config.RateLimit)
+	if err != nil {
+		return err
+	}
+
+	c.subOpts, err = c.subscribeOptions()
+	if err != nil {
+		return err
+	}
+
+	c.parts, err = newPartitioner(c.config, c.subOpts.MaxInflight)
+	if err != nil {
+		return err
+	}
+
+	c.drainTimeout = 10 * time.Second
+	if c.config.DrainTimeout != "" {
+		c.drainTimeout, err = time.ParseDuration(c.config.DrainTimeout)
+		if err != nil {
+			return fmt.Errorf("invalid drain_timeout: %s", err)
+		}
+
+		if c.drainTimeout < 0 {
+			return fmt.Errorf("drain_timeout cannot be negative")
+		}
+	}
+
+	c.lag, err = newLagMonitor(c.config)
+	if err != nil {
+		return err
+	}
+
+	if c.config.MaxPending < 0 {
+		return fmt.Errorf("max_pending cannot be negative")
+	}
+
+	if c.config.PublishAsync && c.config.MaxPending == 0 {
+		c.config.MaxPending = 100
+	}
+
+	if c.config.DeadLetter != nil {
+		if c.config.DeadLetter.Subject == "" {
+			return fmt.Errorf("a dead letter subject is required")
+		}
+
+		if c.config.DeadLetter.Cluster == "" {
+			c.config.DeadLetter.Cluster = "source"
+		}
+
+		if c.config.DeadLetter.Cluster != "source" && c.config.DeadLetter.Cluster != "target" {
+			return fmt.Errorf("invalid dead letter cluster %q, valid clusters are source and target", c.config.DeadLetter.Cluster)
+		}
+
+		if c.config.DeadLetter.Attempts <= 0 {
+			c.config.DeadLetter.Attempts = 5
+		}
+
+		c.failures = newFailureTracker()
+	}
+
 	c.Log = logrus.WithFields(logrus.Fields{"topic": c.config.Topic, "workers": c.config.Workers, "name": c.config.Name, "queue": c.config.QueueGroup})
 
 	c.ctx, c.cancel = context.WithCancel(context.Background())
@@ -72,6 +210,131 @@ func (c *Copier) Setup(name string, topic *config.TopicConf) error {
 	return nil
 }
 
+// setupTargets validates the targets a topic is copied to, a topic configured
+// with a single target_url is treated as having one target and the first target
+// is used where a single target is needed like the advisor and dead letters
+func (c *Copier) setupTargets() error {
+	if len(c.config.Targets) > 0 && c.config.TargetURL != "" && c.config.TargetURL != c.config.Targets[0].URL {
+		return fmt.Errorf("only one of target_url or targets can be set")
+	}
+
+	if len(c.config.Targets) == 0 {
+		if c.config.TargetURL == "" {
+			return fmt.Errorf("a destination URL is required")
+		}
+
+		c.config.Targets = []*config.TargetConf{
+			{URL: c.config.TargetURL, ID: c.config.TargetID, Type: c.config.TargetType},
+		}
+	}
+
+	names := make(map[string]bool)
+
+	for i, t := range c.config.Targets {
+		if t.URL == "" {
+			return fmt.Errorf("a destination URL is required for target %d", i)
+		}
+
+		if t.Type != "" && t.Type != config.StreamingType && t.Type != config.JetStreamType {
+			return fmt.Errorf("invalid stream type %q, valid types are %s and %s", t.Type, config.StreamingType, config.JetStreamType)
+		}
+
+		if t.ID == "" && !t.JetStream() {
+			return fmt.Errorf("a target cluster id is required for target %d", i)
+		}
+
+		if t.Name == "" {
+			t.Name = t.ID
+		}
+
+		if t.Name == "" {
+			t.Name = fmt.Sprintf("target_%d", i)
+		}
+
+		if names[t.Name] {
+			return fmt.Errorf("duplicate target name %q", t.Name)
+		}
+
+		names[t.Name] = true
+	}
+
+	c.config.TargetURL = c.config.Targets[0].URL
+	c.config.TargetID = c.config.Targets[0].ID
+	c.config.TargetType = c.config.Targets[0].Type
+
+	if c.config.TargetPolicy == "" {
+		c.config.TargetPolicy = config.AllTargetsPolicy
+	}
+
+	if c.config.TargetPolicy != config.AllTargetsPolicy && c.config.TargetPolicy != config.BestEffortPolicy {
+		return fmt.Errorf("invalid target policy %q, valid policies are %s and %s", c.config.TargetPolicy, config.AllTargetsPolicy, config.BestEffortPolicy)
+	}
+
+	return nil
+}
+
+func (c *Copier) subscribeOptions() (connector.SubscribeOptions, error) {
+	opts := connector.SubscribeOptions{
+		Durable:       c.config.Name,
+		MaxInflight:   c.config.MaxInflight,
+		StartSequence: c.config.StartSequence,
+		LastReceived:  c.config.StartLast,
+		NewOnly:       c.config.StartNew,
+	}
+
+	if opts.MaxInflight < 0 {
+		return opts, fmt.Errorf("max_inflight cannot be negative")
+	}
+
+	if opts.MaxInflight == 0 {
+		opts.MaxInflight = 10
+	}
+
+	var err error
+
+	if c.config.AckWait != "" {
+		opts.AckWait, err = time.ParseDuration(c.config.AckWait)
+		if err != nil {
+			return opts, fmt.Errorf("invalid ack_wait: %s", err)
+		}
+
+		if opts.AckWait < time.Second {
+			return opts, fmt.Errorf("ack_wait should be at least 1s")
+		}
+	}
+
+	if c.config.StartTime != "" {
+		opts.StartTime, err = time.Parse(time.RFC3339, c.config.StartTime)
+		if err != nil {
+			return opts, fmt.Errorf("invalid start_at_time, it should be a RFC3339 timestamp: %s", err)
+		}
+	}
+
+	if c.config.StartDelta != "" {
+		opts.StartDelta, err = time.ParseDuration(c.config.StartDelta)
+		if err != nil {
+			return opts, fmt.Errorf("invalid start_at_time_delta: %s", err)
+		}
+
+		if opts.StartDelta <= 0 {
+			return opts, fmt.Errorf("start_at_time_delta should be positive")
+		}
+	}
+
+	starts := 0
+	for _, set := range []bool{opts.StartSequence > 0, !opts.StartTime.IsZero(), opts.StartDelta > 0, opts.LastReceived, opts.NewOnly} {
+		if set {
+			starts++
+		}
+	}
+
+	if starts > 1 {
+		return opts, fmt.Errorf("only one of start_at_sequence, start_at_time, start_at_time_delta, start_with_last_received or start_new_only can be set")
+	}
+
+	return opts, nil
+}
+
 // Run starts all the worker in a replicator
 func (c *Copier) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
@@ -79,31 +342,205 @@ func (c *Copier) Run(ctx context.Context, wg *sync.WaitGroup) {
 	if c.config.Inspect != "" && c.config.MinAge != "" {
 		c.Log.Infof("Configuring limiter with on key %s with min age %s", c.config.Inspect, c.config.MinAge)
 
-		advisor.Configure(c.tls, c.config)
+		adv, err := advisor.New(c.tls, c.config)
+		if err != nil {
+			c.Log.Errorf("Could not configure advisor: %s", err)
+		}
 
-		err := limiter.Configure(c.ctx, wg, c.config, &memory.Limiter{})
+		lim, err := limiter.New(c.ctx, wg, c.config, &memory.Limiter{}, adv)
 		if err != nil {
 			c.Log.Errorf("Could not configure limiter: %s", err)
 			c.cancel()
 			return
 		}
 
-		advisor.Connect(c.ctx, wg)
+		c.mu.Lock()
+		c.advisor = adv
+		c.limiter = lim
+		c.mu.Unlock()
+
+		adv.Connect(c.ctx, wg)
 	}
 
-	for i := 0; i < c.config.Workers; i++ {
-		w := newWorker(i, c.config, c.tls, c.Log)
+	if c.lag != nil {
 		wg.Add(1)
-		go w.Run(ctx, wg)
+		go c.lag.Monitor(c.ctx, wg)
 	}
 
+	c.mu.Lock()
+	c.started = time.Now()
+	c.runctx = ctx
+	c.running = &sync.WaitGroup{}
+	c.scale(c.config.Workers)
+	c.mu.Unlock()
+
 	<-ctx.Done()
+
+	// the limiter and advisor keep running while workers drain
+	c.running.Wait()
+
+	err := c.limiter.Flush()
+	if err != nil {
+		c.Log.Errorf("Could not save limiter state: %s", err)
+	}
+
 	c.cancel()
 }
 
-// SetupPrometheus starts a prometheus exporter
-func (c *Copier) SetupPrometheus(port int) {
-	c.Log.Infof("Listening for /metrics on %d", port)
+// Status is the current state of a copier
+type Status struct {
+	Name    string     `json:"name"`
+	Topic   string     `json:"topic"`
+	Workers int        `json:"workers"`
+	Lag     *LagStatus `json:"lag,omitempty"`
+}
+
+// scale starts or stops workers till n are running, stopped workers drain in
+// the background, it should be called with mu held
+func (c *Copier) scale(n int) {
+	for len(c.workers) < n {
+		ctx, cancel := context.WithCancel(c.runctx)
+
+		w := newWorker(len(c.workers), c)
+		w.cancel = cancel
+		c.workers = append(c.workers, w)
+
+		c.running.Add(1)
+		go w.Run(ctx, c.running)
+	}
+
+	for len(c.workers) > n {
+		last := len(c.workers) - 1
+		c.workers[last].cancel()
+		c.workers = c.workers[:last]
+	}
+}
+
+// Status reports the current state of the copier
+func (c *Copier) Status() *Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status()
+}
+
+func (c *Copier) status() *Status {
+	s := &Status{
+		Name:    c.config.Name,
+		Topic:   c.config.Topic,
+		Workers: c.config.Workers,
+	}
+
+	if c.lag != nil {
+		lag := c.lag.Status()
+		s.Lag = &lag
+	}
+
+	return s
+}
+
+// SetRateLimit adjusts the rate limits of a running copier, a nil configuration removes all limits
+func (c *Copier) SetRateLimit(rl *config.RateLimitConf) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.throttle.Configure(rl)
+	if err != nil {
+		return err
+	}
+
+	c.config.RateLimit = rl
+
+	return nil
+}
+
+// Pause stops all workers from receiving new messages, messages being copied are completed
+func (c *Copier) Pause() error {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+
+	workers := c.pause(true)
+
+	// in partitioned mode all workers copy messages from the subscription of the
+	// first one, so all stop before any subscription is closed
+	for _, w := range workers {
+		w.stop()
+	}
+
+	for _, w := range workers {
+		err := w.pause()
+		if err != nil {
+			return fmt.Errorf("could not pause %s: %s", w.name, err)
+		}
+	}
+
+	return nil
+}
+
+// Resume starts receiving messages again after Pause
+func (c *Copier) Resume() error {
+	c.pmu.Lock()
+	defer c.pmu.Unlock()
+
+	for _, w := range c.pause(false) {
+		err := w.resume()
+		if err != nil {
+			return fmt.Errorf("could not resume %s: %s", w.name, err)
+		}
+	}
+
+	return nil
+}
+
+// pause records if the copier is paused and returns the running workers, workers
+// started later by a reload are created in the recorded state
+func (c *Copier) pause(paused bool) []*worker {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.paused = paused
+
+	return append([]*worker{}, c.workers...)
+}
+
+// Paused determines if the copier is paused
+func (c *Copier) Paused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+// Flush saves the limiter state to the state directory
+func (c *Copier) Flush() error {
+	c.mu.Lock()
+	lim := c.limiter
+	c.mu.Unlock()
+
+	return lim.Flush()
+}
+
+// Copiers finds the running copiers, the monitoring handlers call it on every request
+// so they see copiers that were started or stopped by a reload
+type Copiers func() []*Copier
+
+// SetupStatus serves the status of copiers as JSON on /status of the monitoring port
+func SetupStatus(copiers Copiers) {
+	http.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := make(map[string]*Status)
+		for _, c := range copiers() {
+			status[c.config.Name] = c.Status()
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+}
+
+// SetupPrometheus starts a prometheus exporter, it should be called once per process
+// regardless of how many copiers are running
+func SetupPrometheus(port int) {
+	logrus.Infof("Listening for /metrics on %d", port)
 	http.Handle("/metrics", promhttp.Handler())
-	c.Log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	logrus.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
 }
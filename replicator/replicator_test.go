@@ -0,0 +1,136 @@
+package replicator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+func TestFederation(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Replicator")
+}
+
+var _ = Describe("Copier", func() {
+	var (
+		c    *Copier
+		conf *config.TopicConf
+	)
+
+	BeforeEach(func() {
+		c = &Copier{}
+		conf = &config.TopicConf{
+			Topic:     "acme.cmdb",
+			SourceID:  "dc1",
+			TargetURL: "nats://localhost:4222",
+			TargetID:  "dc2",
+		}
+	})
+
+	Describe("Setup", func() {
+		It("Should treat a single target as a list of targets", func() {
+			Expect(c.Setup("test", conf)).To(Succeed())
+			Expect(conf.Targets).To(HaveLen(1))
+			Expect(conf.Targets[0].Name).To(Equal("dc2"))
+			Expect(conf.Targets[0].URL).To(Equal("nats://localhost:4222"))
+			Expect(conf.TargetPolicy).To(Equal(config.AllTargetsPolicy))
+		})
+
+		It("Should validate targets", func() {
+			conf.TargetURL = ""
+			conf.TargetID = ""
+			conf.Targets = []*config.TargetConf{
+				{URL: "nats://dc2:4222", ID: "dc2"},
+				{URL: "nats://dc3:4222", Type: config.JetStreamType},
+			}
+
+			Expect(c.Setup("test", conf)).To(Succeed())
+			Expect(conf.Targets[1].Name).To(Equal("target_1"))
+			Expect(conf.TargetURL).To(Equal("nats://dc2:4222"))
+			Expect(conf.TargetID).To(Equal("dc2"))
+
+			conf.Targets[1].Type = ""
+			Expect(c.Setup("test", conf)).To(MatchError("a target cluster id is required for target 1"))
+
+			conf.Targets[1].ID = "dc2"
+			conf.Targets[1].Name = ""
+			Expect(c.Setup("test", conf)).To(MatchError(`duplicate target name "dc2"`))
+
+			conf.Targets[1].Name = "dc3"
+			conf.TargetPolicy = "some"
+			Expect(c.Setup("test", conf)).To(MatchError(`invalid target policy "some", valid policies are all and best_effort`))
+		})
+
+		It("Should not queue partitioned workers", func() {
+			conf.Workers = 4
+			conf.Partitioned = true
+			conf.Inspect = "sender"
+
+			Expect(c.Setup("test", conf)).To(Succeed())
+			Expect(conf.Queued).To(BeFalse())
+			Expect(c.parts.queues).To(HaveLen(4))
+
+			conf.PublishAsync = true
+			Expect(c.Setup("test", conf)).To(MatchError("partitioned replication cannot be combined with publish_async"))
+		})
+
+		It("Should not limit asynchronously published topics", func() {
+			conf.PublishAsync = true
+			conf.Inspect = "sender"
+			Expect(c.Setup("test", conf)).To(Succeed())
+
+			conf.MinAge = "1h"
+			Expect(c.Setup("test", conf)).To(MatchError("publish_async cannot be combined with inspect and age"))
+		})
+
+		It("Should set dead letter defaults", func() {
+			conf.DeadLetter = &config.DeadLetterConf{}
+			Expect(c.Setup("test", conf)).To(MatchError("a dead letter subject is required"))
+
+			conf.DeadLetter.Subject = "sr.dead"
+			Expect(c.Setup("test", conf)).To(Succeed())
+			Expect(conf.DeadLetter.Cluster).To(Equal("source"))
+			Expect(conf.DeadLetter.Attempts).To(Equal(5))
+
+			conf.DeadLetter.Cluster = "other"
+			Expect(c.Setup("test", conf)).To(MatchError(`invalid dead letter cluster "other", valid clusters are source and target`))
+		})
+
+		It("Should set subscription defaults", func() {
+			Expect(c.Setup("test", conf)).To(Succeed())
+			Expect(c.subOpts.Durable).To(Equal("test_acme_cmdb_stream_replicator"))
+			Expect(c.subOpts.MaxInflight).To(Equal(10))
+			Expect(c.subOpts.AckWait).To(BeZero())
+		})
+
+		It("Should parse start positions and flow control", func() {
+			conf.StartTime = "2022-01-01T00:00:00Z"
+			conf.MaxInflight = 100
+			conf.AckWait = "1m"
+
+			Expect(c.Setup("test", conf)).To(Succeed())
+			Expect(c.subOpts.StartTime).To(Equal(time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)))
+			Expect(c.subOpts.MaxInflight).To(Equal(100))
+			Expect(c.subOpts.AckWait).To(Equal(time.Minute))
+		})
+
+		It("Should only allow one start position", func() {
+			conf.StartSequence = 10
+			conf.StartNew = true
+
+			Expect(c.Setup("test", conf)).To(MatchError("only one of start_at_sequence, start_at_time, start_at_time_delta, start_with_last_received or start_new_only can be set"))
+		})
+
+		It("Should validate durations", func() {
+			conf.StartDelta = "yesterday"
+			Expect(c.Setup("test", conf)).To(MatchError(`invalid start_at_time_delta: time: invalid duration "yesterday"`))
+
+			conf.StartDelta = ""
+			conf.AckWait = "10ms"
+			Expect(c.Setup("test", conf)).To(MatchError("ack_wait should be at least 1s"))
+		})
+	})
+})
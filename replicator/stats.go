@@ -1,6 +1,9 @@
 package replicator
 
 import (
+	"fmt"
+	"sync"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -25,25 +28,88 @@ var (
 		Help: "Size of messages that were copied",
 	}, []string{"name", "worker"})
 
+	compressedBytesCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_copied_compressed_bytes",
+		Help: "Size of messages that were copied after compression",
+	}, []string{"name", "worker"})
+
+	filterSkippedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_filter_skipped_msgs",
+		Help: "How many messages were skipped because they did not match the filter",
+	}, []string{"name", "worker"})
+
+	loopSkippedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_loop_skipped_msgs",
+		Help: "How many messages were skipped because they were already copied from a target",
+	}, []string{"name", "worker"})
+
+	duplicateSkippedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_duplicate_skipped_msgs",
+		Help: "How many redelivered messages were skipped because they were already copied",
+	}, []string{"name", "worker"})
+
 	failedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "stream_replicator_failed_msgs",
 		Help: "How many messages failed to copy to the remote server",
 	}, []string{"name", "worker"})
 
+	targetCopiedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_target_copied_msgs",
+		Help: "How many messages were copied to a specific target",
+	}, []string{"name", "worker", "target"})
+
+	targetFailedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_target_failed_msgs",
+		Help: "How many messages failed to copy to a specific target",
+	}, []string{"name", "worker", "target"})
+
+	deadLetterCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_dead_lettered_msgs",
+		Help: "How many messages were sent to the dead letter subject after failing to copy",
+	}, []string{"name", "worker"})
+
 	ackFailedCtr = prometheus.NewCounterVec(prometheus.CounterOpts{
 		Name: "stream_replicator_acks_failed",
 		Help: "How many times ack'ing a message failed",
 	}, []string{"name", "worker"})
 
+	throttledTime = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "stream_replicator_throttled_seconds",
+		Help: "How long copying was delayed by the rate limits",
+	}, []string{"name", "worker"})
+
 	processTime = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Name: "stream_replicator_processing_time",
 		Help: "How long it took to process messages",
 	}, []string{"name", "worker"})
 
+	latencyHist = newLatencyHistogram(nil)
+	latencyMu   sync.Mutex
+
 	sequenceGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "stream_replicator_current_sequence",
 		Help: "The current sequence number being copied",
 	}, []string{"name", "worker"})
+
+	sourceSequenceGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stream_replicator_source_last_sequence",
+		Help: "The last sequence in the source channel",
+	}, []string{"name"})
+
+	pendingGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stream_replicator_source_pending_msgs",
+		Help: "Messages delivered to the durable subscription that were not yet acknowledged",
+	}, []string{"name"})
+
+	lagMsgsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stream_replicator_lag_msgs",
+		Help: "How many messages in the source channel were not yet copied",
+	}, []string{"name"})
+
+	lagSecondsGauge = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "stream_replicator_lag_seconds",
+		Help: "How long ago the last copied message was received by the source while messages are waiting to be copied",
+	}, []string{"name"})
 )
 
 func init() {
@@ -51,8 +117,67 @@ func init() {
 	prometheus.MustRegister(receivedBytesCtr)
 	prometheus.MustRegister(copiedCtr)
 	prometheus.MustRegister(copiedBytesCtr)
+	prometheus.MustRegister(compressedBytesCtr)
+	prometheus.MustRegister(filterSkippedCtr)
+	prometheus.MustRegister(loopSkippedCtr)
+	prometheus.MustRegister(duplicateSkippedCtr)
 	prometheus.MustRegister(failedCtr)
+	prometheus.MustRegister(targetCopiedCtr)
+	prometheus.MustRegister(targetFailedCtr)
+	prometheus.MustRegister(deadLetterCtr)
 	prometheus.MustRegister(ackFailedCtr)
+	prometheus.MustRegister(throttledTime)
 	prometheus.MustRegister(processTime)
+	prometheus.MustRegister(latencyHist)
 	prometheus.MustRegister(sequenceGauge)
+	prometheus.MustRegister(sourceSequenceGauge)
+	prometheus.MustRegister(pendingGauge)
+	prometheus.MustRegister(lagMsgsGauge)
+	prometheus.MustRegister(lagSecondsGauge)
+}
+
+func newLatencyHistogram(buckets []float64) *prometheus.HistogramVec {
+	if len(buckets) == 0 {
+		buckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+	}
+
+	return prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "stream_replicator_replication_latency_seconds",
+		Help:    "Time from a message being received by the source till the target acknowledged the copy",
+		Buckets: buckets,
+	}, []string{"name", "worker"})
+}
+
+// SetLatencyBuckets replaces the buckets in seconds of the replication latency histogram,
+// it should be called before any copier is started
+func SetLatencyBuckets(buckets []float64) error {
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i] <= buckets[i-1] {
+			return fmt.Errorf("latency buckets should be in increasing order")
+		}
+	}
+
+	latencyMu.Lock()
+	defer latencyMu.Unlock()
+
+	hist := newLatencyHistogram(buckets)
+
+	prometheus.Unregister(latencyHist)
+	err := prometheus.Register(hist)
+	if err != nil {
+		prometheus.MustRegister(latencyHist)
+		return err
+	}
+
+	latencyHist = hist
+
+	return nil
+}
+
+func observeLatency(name string, worker string, seconds float64) {
+	latencyMu.Lock()
+	hist := latencyHist
+	latencyMu.Unlock()
+
+	hist.WithLabelValues(name, worker).Observe(seconds)
 }
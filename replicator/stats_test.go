@@ -0,0 +1,32 @@
+package replicator
+
+import (
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+var _ = Describe("Stats", func() {
+	Describe("SetLatencyBuckets", func() {
+		AfterEach(func() {
+			Expect(SetLatencyBuckets(nil)).To(Succeed())
+		})
+
+		It("Should require increasing buckets", func() {
+			Expect(SetLatencyBuckets([]float64{1, 1})).To(MatchError("latency buckets should be in increasing order"))
+		})
+
+		It("Should replace the histogram", func() {
+			Expect(SetLatencyBuckets([]float64{1, 5, 10})).To(Succeed())
+
+			observeLatency("test", "test", 2)
+
+			m := &dto.Metric{}
+			Expect(latencyHist.WithLabelValues("test", "test").(prometheus.Histogram).Write(m)).To(Succeed())
+			Expect(m.Histogram.Bucket).To(HaveLen(3))
+			Expect(m.Histogram.Bucket[0].GetCumulativeCount()).To(Equal(uint64(0)))
+			Expect(m.Histogram.Bucket[1].GetCumulativeCount()).To(Equal(uint64(1)))
+		})
+	})
+})
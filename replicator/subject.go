@@ -0,0 +1,79 @@
+package replicator
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/tidwall/gjson"
+)
+
+// subjectRewriter determines the subject messages are published to on the target
+type subjectRewriter struct {
+	tmpl   *template.Template
+	config *config.TopicConf
+}
+
+// subjectData is the data available to target_subject templates
+type subjectData struct {
+	SourceID string
+	TargetID string
+	Topic    string
+	Name     string
+	Subject  string
+
+	msg *connector.Msg
+}
+
+// Lookup retrieves a value from the JSON message body using a gjson path
+func (d subjectData) Lookup(path string) string {
+	return gjson.GetBytes(d.msg.Data, path).String()
+}
+
+func newSubjectRewriter(c *config.TopicConf) (*subjectRewriter, error) {
+	r := &subjectRewriter{config: c}
+
+	if c.TargetSubject == "" {
+		return r, nil
+	}
+
+	var err error
+	r.tmpl, err = template.New("target_subject").Parse(c.TargetSubject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target subject %q: %s", c.TargetSubject, err)
+	}
+
+	return r, nil
+}
+
+// Subject is the subject to publish msg to on the target with cluster id target
+func (r *subjectRewriter) Subject(msg *connector.Msg, target string) (string, error) {
+	if r.tmpl == nil {
+		return msg.Subject, nil
+	}
+
+	data := subjectData{
+		SourceID: r.config.SourceID,
+		TargetID: target,
+		Topic:    r.config.Topic,
+		Name:     r.config.Name,
+		Subject:  msg.Subject,
+		msg:      msg,
+	}
+
+	var b bytes.Buffer
+	err := r.tmpl.Execute(&b, data)
+	if err != nil {
+		return "", fmt.Errorf("could not render target subject: %s", err)
+	}
+
+	subject := strings.TrimSpace(b.String())
+	if subject == "" || strings.Contains(subject, "..") || strings.HasPrefix(subject, ".") || strings.HasSuffix(subject, ".") {
+		return "", fmt.Errorf("target subject %q rendered to invalid subject %q", r.config.TargetSubject, subject)
+	}
+
+	return subject, nil
+}
@@ -0,0 +1,76 @@
+package replicator
+
+import (
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Subject Rewriter", func() {
+	var (
+		conf *config.TopicConf
+		msg  *connector.Msg
+	)
+
+	BeforeEach(func() {
+		conf = &config.TopicConf{
+			Topic:    "acme.cmdb",
+			SourceID: "dc1",
+			TargetID: "dc2",
+			Name:     "testing",
+		}
+
+		msg = &connector.Msg{Subject: "acme.cmdb", Data: []byte(`{"sender":"node1.example.net","site":"dc1"}`)}
+	})
+
+	It("Should default to the source subject", func() {
+		r, err := newSubjectRewriter(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Subject(msg, "dc2")).To(Equal("acme.cmdb"))
+	})
+
+	It("Should support fixed subjects", func() {
+		conf.TargetSubject = "global.cmdb"
+		r, err := newSubjectRewriter(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Subject(msg, "dc2")).To(Equal("global.cmdb"))
+	})
+
+	It("Should support templates", func() {
+		conf.TargetSubject = "{{.SourceID}}.{{.Subject}}"
+		r, err := newSubjectRewriter(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Subject(msg, "dc2")).To(Equal("dc1.acme.cmdb"))
+	})
+
+	It("Should render the subject for each target", func() {
+		conf.TargetSubject = "{{.TargetID}}.{{.Subject}}"
+		r, err := newSubjectRewriter(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Subject(msg, "dc2")).To(Equal("dc2.acme.cmdb"))
+		Expect(r.Subject(msg, "dc3")).To(Equal("dc3.acme.cmdb"))
+	})
+
+	It("Should support values from the message body", func() {
+		conf.TargetSubject = `{{.Lookup "site"}}.acme.cmdb`
+		r, err := newSubjectRewriter(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(r.Subject(msg, "dc2")).To(Equal("dc1.acme.cmdb"))
+	})
+
+	It("Should fail for invalid templates", func() {
+		conf.TargetSubject = "{{.SourceID"
+		_, err := newSubjectRewriter(conf)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should fail for invalid rendered subjects", func() {
+		conf.TargetSubject = `{{.Lookup "missing"}}.acme.cmdb`
+		r, err := newSubjectRewriter(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = r.Subject(msg, "dc2")
+		Expect(err).To(MatchError(`target subject "{{.Lookup \"missing\"}}.acme.cmdb" rendered to invalid subject ".acme.cmdb"`))
+	})
+})
@@ -0,0 +1,73 @@
+package replicator
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/choria-io/stream-replicator/config"
+)
+
+// target is one of the clusters a worker copies messages to
+type target struct {
+	name string
+	id   string
+	conn stream
+}
+
+// fanout collects the results of publishing a message to all targets
+// and calls done once every target has reported back
+type fanout struct {
+	policy    string
+	targets   int
+	remaining int
+	errs      []error
+	done      func(err error)
+	mu        sync.Mutex
+}
+
+func newFanout(policy string, targets int, done func(err error)) *fanout {
+	return &fanout{
+		policy:    policy,
+		targets:   targets,
+		remaining: targets,
+		done:      done,
+	}
+}
+
+// Result records the outcome of publishing to a target
+func (f *fanout) Result(err error) {
+	f.mu.Lock()
+
+	if err != nil {
+		f.errs = append(f.errs, err)
+	}
+
+	f.remaining--
+	if f.remaining > 0 {
+		f.mu.Unlock()
+		return
+	}
+
+	err = f.outcome()
+	f.mu.Unlock()
+
+	f.done(err)
+}
+
+// outcome applies the target policy to the collected errors, with the all policy
+// any failure fails the message while best effort only fails when no target succeeded
+func (f *fanout) outcome() error {
+	if len(f.errs) == 0 {
+		return nil
+	}
+
+	if f.policy == config.BestEffortPolicy && len(f.errs) < f.targets {
+		return nil
+	}
+
+	if len(f.errs) == 1 {
+		return f.errs[0]
+	}
+
+	return fmt.Errorf("publishing to %d targets failed: %s", len(f.errs), f.errs[0])
+}
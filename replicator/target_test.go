@@ -0,0 +1,55 @@
+package replicator
+
+import (
+	"errors"
+
+	"github.com/choria-io/stream-replicator/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Fanout", func() {
+	var (
+		result error
+		done   bool
+	)
+
+	complete := func(err error) {
+		result = err
+		done = true
+	}
+
+	BeforeEach(func() {
+		result = nil
+		done = false
+	})
+
+	It("Should only complete once all targets reported", func() {
+		f := newFanout(config.AllTargetsPolicy, 2, complete)
+		f.Result(nil)
+		Expect(done).To(BeFalse())
+		f.Result(nil)
+		Expect(done).To(BeTrue())
+		Expect(result).ToNot(HaveOccurred())
+	})
+
+	It("Should require all targets to succeed", func() {
+		f := newFanout(config.AllTargetsPolicy, 2, complete)
+		f.Result(nil)
+		f.Result(errors.New("dc3: timeout"))
+		Expect(result).To(MatchError("dc3: timeout"))
+	})
+
+	It("Should succeed on best effort when any target succeeded", func() {
+		f := newFanout(config.BestEffortPolicy, 3, complete)
+		f.Result(errors.New("dc2: timeout"))
+		f.Result(nil)
+		f.Result(errors.New("dc3: timeout"))
+		Expect(result).ToNot(HaveOccurred())
+
+		f = newFanout(config.BestEffortPolicy, 2, complete)
+		f.Result(errors.New("dc2: timeout"))
+		f.Result(errors.New("dc3: timeout"))
+		Expect(result).To(MatchError("publishing to 2 targets failed: dc2: timeout"))
+	})
+})
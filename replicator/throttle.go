@@ -0,0 +1,87 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"golang.org/x/time/rate"
+)
+
+// throttle limits how many messages and bytes are copied per second using
+// token buckets shared by all the workers of a copier
+type throttle struct {
+	msgs  *rate.Limiter
+	bytes *rate.Limiter
+}
+
+func newThrottle(c *config.RateLimitConf) (*throttle, error) {
+	t := &throttle{
+		msgs:  rate.NewLimiter(rate.Inf, 1),
+		bytes: rate.NewLimiter(rate.Inf, 1),
+	}
+
+	err := t.Configure(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// Configure adjusts the limits, it is safe to call while messages are being copied and a nil configuration removes all limits
+func (t *throttle) Configure(c *config.RateLimitConf) error {
+	if c == nil {
+		c = &config.RateLimitConf{}
+	}
+
+	if c.Messages < 0 || c.Bytes < 0 || c.MessageBurst < 0 || c.ByteBurst < 0 {
+		return fmt.Errorf("rate limits cannot be negative")
+	}
+
+	mburst := c.MessageBurst
+	if mburst == 0 {
+		mburst = int(math.Max(1, math.Ceil(c.Messages)))
+	}
+
+	bburst := c.ByteBurst
+	if bburst == 0 {
+		bburst = int(math.Max(1, math.Ceil(c.Bytes)))
+	}
+
+	t.msgs.SetBurst(mburst)
+	t.msgs.SetLimit(limit(c.Messages))
+	t.bytes.SetBurst(bburst)
+	t.bytes.SetLimit(limit(c.Bytes))
+
+	return nil
+}
+
+func limit(l float64) rate.Limit {
+	if l == 0 {
+		return rate.Inf
+	}
+
+	return rate.Limit(l)
+}
+
+// Wait blocks until a message of size bytes can be copied and returns how long it waited,
+// messages larger than the byte burst consume the entire burst
+func (t *throttle) Wait(ctx context.Context, size int) (time.Duration, error) {
+	start := time.Now()
+
+	err := t.msgs.Wait(ctx)
+	if err != nil {
+		return time.Since(start), err
+	}
+
+	if size > t.bytes.Burst() {
+		size = t.bytes.Burst()
+	}
+
+	err = t.bytes.WaitN(ctx, size)
+
+	return time.Since(start), err
+}
@@ -0,0 +1,58 @@
+package replicator
+
+import (
+	"context"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Throttle", func() {
+	It("Should not limit by default", func() {
+		t, err := newThrottle(nil)
+		Expect(err).ToNot(HaveOccurred())
+
+		for i := 0; i < 1000; i++ {
+			waited, err := t.Wait(context.Background(), 1024)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(waited).To(BeNumerically("<", 10*time.Millisecond))
+		}
+	})
+
+	It("Should reject negative limits", func() {
+		_, err := newThrottle(&config.RateLimitConf{Messages: -1})
+		Expect(err).To(MatchError("rate limits cannot be negative"))
+	})
+
+	It("Should limit messages and bytes", func() {
+		t, err := newThrottle(&config.RateLimitConf{Messages: 10})
+		Expect(err).ToNot(HaveOccurred())
+
+		_, err = t.Wait(context.Background(), 1)
+		Expect(err).ToNot(HaveOccurred())
+
+		// burst of 10 messages is used up, the next wait has to wait for a token
+		for i := 0; i < 9; i++ {
+			t.Wait(context.Background(), 1)
+		}
+
+		waited, err := t.Wait(context.Background(), 1)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(waited).To(BeNumerically(">", 50*time.Millisecond))
+
+		Expect(t.Configure(&config.RateLimitConf{Bytes: 1000})).To(Succeed())
+
+		// messages larger than the burst use the whole burst
+		waited, err = t.Wait(context.Background(), 5000)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(waited).To(BeNumerically("<", 10*time.Millisecond))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		_, err = t.Wait(ctx, 1000)
+		Expect(err).To(HaveOccurred())
+	})
+})
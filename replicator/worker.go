@@ -3,39 +3,99 @@ package replicator
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/choria-io/stream-replicator/backoff"
 	"github.com/choria-io/stream-replicator/config"
 	"github.com/choria-io/stream-replicator/connector"
+	"github.com/choria-io/stream-replicator/envelope"
 	"github.com/choria-io/stream-replicator/limiter"
-	"github.com/nats-io/stan.go"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sirupsen/logrus"
 )
 
 type stream interface {
 	Connect(ctx context.Context)
-	Subscribe(subject string, qgroup string, cb stan.MsgHandler, opts ...stan.SubscriptionOption) error
+	Subscribe(subject string, qgroup string, cb connector.MsgHandler, opts connector.SubscribeOptions) error
 	Publish(subject string, data []byte) error
+	PublishWithID(subject string, id string, data []byte) error
+	PublishAsync(subject string, data []byte, cb func(err error)) error
+	State() string
+	Disconnected() time.Duration
+	CloseSubscriptions() error
 	Close() error
 }
 
 type worker struct {
-	name string
+	// inflight is the number of messages being copied, accessed atomically
+	inflight int64
 
-	from   stream
-	to     stream
-	config *config.TopicConf
-	tls    bool
-	log    *logrus.Entry
+	// lastSequence is the sequence of the last message acked, accessed atomically
+	lastSequence uint64
+
+	// lastAcked is when a message was last acked in unix nanoseconds, accessed atomically
+	lastAcked int64
+
+	// stopping is 1 while the worker does not copy new messages, accessed atomically
+	stopping int32
+
+	name   string
+	index  int
+	ctx    context.Context
+	cancel func()
+
+	// mu protects the connections and subscription state that the admin api accesses
+	mu         sync.Mutex
+	from       stream
+	targets    []*target
+	paused     bool
+	subscribed bool
+
+	config   *config.TopicConf
+	tls      bool
+	limiter  *limiter.Limiter
+	subject  *subjectRewriter
+	filter   *filter
+	wrapper  *wrapper
+	loop     *loopDetector
+	dedupe   *dedupe
+	throttle *throttle
+	parts    *partitioner
+	lag      *lagMonitor
+	subOpts  connector.SubscribeOptions
+	failures *failureTracker
+	pending  chan struct{}
+	drain    time.Duration
+	log      *logrus.Entry
 }
 
-func newWorker(i int, config *config.TopicConf, tls bool, log *logrus.Entry) *worker {
+func newWorker(i int, c *Copier) *worker {
 	w := worker{
-		name:   fmt.Sprintf("%s_%d", config.Name, i),
-		log:    log.WithFields(logrus.Fields{"worker": i}),
-		config: config,
-		tls:    tls,
+		name:     fmt.Sprintf("%s_%d", c.config.Name, i),
+		index:    i,
+		log:      c.Log.WithFields(logrus.Fields{"worker": i}),
+		config:   c.config,
+		tls:      c.tls,
+		limiter:  c.limiter,
+		subject:  c.subject,
+		filter:   c.filter,
+		wrapper:  c.wrapper,
+		loop:     c.loop,
+		dedupe:   c.dedupe,
+		throttle: c.throttle,
+		parts:    c.parts,
+		lag:      c.lag,
+		subOpts:  c.subOpts,
+		failures: c.failures,
+		drain:    c.drainTimeout,
+		paused:   c.paused,
+	}
+
+	if c.config.PublishAsync {
+		w.pending = make(chan struct{}, c.config.MaxPending)
 	}
 
 	return &w
@@ -44,101 +104,741 @@ func newWorker(i int, config *config.TopicConf, tls bool, log *logrus.Entry) *wo
 func (w *worker) Run(ctx context.Context, wg *sync.WaitGroup) {
 	defer wg.Done()
 
+	w.ctx = ctx
+
 	err := w.connect(ctx)
 	if err != nil {
 		w.log.Errorf("Could not start worker: %s", err)
 		return
 	}
 
-	err = w.subscribe()
+	if w.parts != nil {
+		go w.partition(w.parts.Queue(w.index))
+	}
+
+	w.mu.Lock()
+	if w.paused {
+		w.log.Infof("%s is paused, not subscribing to source %s", w.name, w.config.Topic)
+	} else {
+		err = w.subscribe()
+		w.subscribed = err == nil
+	}
+	w.mu.Unlock()
+
 	if err != nil {
 		w.log.Errorf("Could not subscribe to source %s", w.config.Topic)
 		return
 	}
 
 	<-ctx.Done()
-	w.log.Infof("%s existing", w.name)
+	w.log.Infof("%s exiting", w.name)
+
+	// resume does not subscribe once ctx is done, so mu is not held while draining
+	w.mu.Lock()
+	w.subscribed = false
+	w.mu.Unlock()
+
+	w.drainInflight()
+
+	err = w.from.CloseSubscriptions()
+	if err != nil {
+		w.log.Warnf("Could not close subscriptions: %s", err)
+	}
+
 	w.from.Close()
-	w.to.Close()
+
+	for _, t := range w.targets {
+		t.conn.Close()
+	}
+}
+
+// drainInflight stops copying new messages and waits for messages being copied
+// to be published and acked, messages not copied within the drain timeout are
+// left to be redelivered. Subscriptions should only be closed once drained as
+// messages cannot be acked after their subscription is closed
+func (w *worker) drainInflight() {
+	w.stop()
+
+	start := time.Now()
+	deadline := time.NewTimer(w.drain)
+	defer deadline.Stop()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	initial := w.busy()
+	if initial == 0 {
+		return
+	}
+
+	w.log.Infof("Draining %d in-flight messages for up to %v", initial, w.drain)
+
+	for {
+		select {
+		case <-ticker.C:
+			if w.busy() == 0 {
+				w.log.Infof("Drained %d in-flight messages in %v", initial, time.Since(start).Round(time.Millisecond))
+				return
+			}
+
+		case <-deadline.C:
+			remaining := w.busy()
+			w.log.Warnf("Drained %d of %d in-flight messages, %d messages were not copied within %v and will be redelivered", initial-remaining, initial, remaining, w.drain)
+			return
+		}
+	}
+}
+
+// stop stops copying new messages, messages received while stopped are not acked and will be redelivered
+func (w *worker) stop() {
+	atomic.StoreInt32(&w.stopping, 1)
 }
 
-func (w *worker) copyf(msg *stan.Msg) {
+// receiving determines if new messages should be copied
+func (w *worker) receiving() bool {
+	if atomic.LoadInt32(&w.stopping) == 1 {
+		return false
+	}
+
+	return w.ctx == nil || w.ctx.Err() == nil
+}
+
+// track adjusts the count of messages being copied
+func (w *worker) track(delta int64) {
+	atomic.AddInt64(&w.inflight, delta)
+}
+
+// busy is the number of messages being copied that were received by the subscriptions of this worker,
+// in partitioned mode messages received by the first worker are dispatched to all workers and are
+// counted by the partitioner till they are copied
+func (w *worker) busy() int64 {
+	if w.parts != nil {
+		return w.parts.Busy()
+	}
+
+	return atomic.LoadInt64(&w.inflight)
+}
+
+// WorkerStatus is the current state of a worker
+type WorkerStatus struct {
+	Name         string            `json:"name"`
+	Source       string            `json:"source"`
+	Targets      map[string]string `json:"targets"`
+	LastSequence uint64            `json:"last_sequence"`
+	Inflight     int64             `json:"inflight"`
+	Paused       bool              `json:"paused"`
+}
+
+func (w *worker) status() WorkerStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	s := WorkerStatus{
+		Name:         w.name,
+		Source:       connector.StateDisconnected,
+		Targets:      make(map[string]string),
+		LastSequence: atomic.LoadUint64(&w.lastSequence),
+		Inflight:     atomic.LoadInt64(&w.inflight),
+		Paused:       w.paused,
+	}
+
+	if w.from != nil {
+		s.Source = w.from.State()
+	}
+
+	for _, t := range w.targets {
+		s.Targets[t.name] = t.conn.State()
+	}
+
+	return s
+}
+
+// health reports connections that have been disconnected for longer than max
+func (w *worker) health(max time.Duration) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	problems := []string{}
+
+	if w.from != nil {
+		if down := w.from.Disconnected(); down > max {
+			problems = append(problems, fmt.Sprintf("%s source has been %s for %v", w.name, w.from.State(), down.Round(time.Second)))
+		}
+	}
+
+	for _, t := range w.targets {
+		if down := t.conn.Disconnected(); down > max {
+			problems = append(problems, fmt.Sprintf("%s target %s has been %s for %v", w.name, t.name, t.conn.State(), down.Round(time.Second)))
+		}
+	}
+
+	return problems
+}
+
+// ready reports connections that are not connected
+func (w *worker) ready() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.from == nil {
+		return []string{fmt.Sprintf("%s has not connected", w.name)}
+	}
+
+	problems := []string{}
+
+	if state := w.from.State(); state != connector.StateConnected {
+		problems = append(problems, fmt.Sprintf("%s source is %s", w.name, state))
+	}
+
+	for _, t := range w.targets {
+		if state := t.conn.State(); state != connector.StateConnected {
+			problems = append(problems, fmt.Sprintf("%s target %s is %s", w.name, t.name, state))
+		}
+	}
+
+	return problems
+}
+
+// pause stops receiving messages from the source, messages being copied are
+// completed before the subscriptions are closed so they can be acked
+func (w *worker) pause() error {
+	w.mu.Lock()
+	w.paused = true
+	subscribed := w.subscribed
+	w.subscribed = false
+	w.mu.Unlock()
+
+	if !subscribed {
+		w.stop()
+		return nil
+	}
+
+	w.log.Infof("Pausing replication of %s", w.config.Topic)
+
+	w.drainInflight()
+
+	return w.from.CloseSubscriptions()
+}
+
+// resume subscribes to the source again after being paused, the subscription
+// is made without holding mu as it waits for the source to be reconnected
+func (w *worker) resume() error {
+	w.mu.Lock()
+	w.paused = false
+
+	if w.subscribed || w.from == nil || w.ctx == nil || w.ctx.Err() != nil {
+		w.mu.Unlock()
+		return nil
+	}
+
+	w.subscribed = true
+	w.mu.Unlock()
+
+	atomic.StoreInt32(&w.stopping, 0)
+
+	w.log.Infof("Resuming replication of %s", w.config.Topic)
+
+	err := w.subscribe()
+	if err != nil {
+		w.mu.Lock()
+		w.subscribed = false
+		w.mu.Unlock()
+	}
+
+	return err
+}
+
+func (w *worker) copyf(msg *connector.Msg) {
+	// counted before checking receiving so draining waits for messages that passed the check
+	w.track(1)
+	defer w.track(-1)
+
+	if !w.receiving() {
+		w.log.Debugf("Not copying message %d received while stopping, it will be redelivered", msg.Sequence)
+		return
+	}
+
+	w.copy(msg)
+}
+
+// copyInOrder copies msg retrying it until it is acked or dead lettered, the next message
+// of the partition is only copied after that so messages with the same key are published in
+// order. Once the worker is stopping msg is left to be redelivered and false is returned
+func (w *worker) copyInOrder(msg *connector.Msg) bool {
+	w.track(1)
+	defer w.track(-1)
+
+	for try := 0; ; try++ {
+		if w.copy(msg) {
+			return true
+		}
+
+		if !w.receiving() {
+			w.log.Warnf("Not retrying message %d while stopping, it will be redelivered", msg.Sequence)
+			return false
+		}
+
+		w.log.Warnf("Retrying message %d before copying later messages of its partition", msg.Sequence)
+
+		if backoff.FiveSec.InterruptableSleep(w.ctx, try) != nil {
+			return false
+		}
+
+		// retries count towards the dead letter attempts like redeliveries do
+		msg.Redelivered = true
+	}
+}
+
+// copy copies msg to the targets, it reports if msg is done with meaning it was acked or
+// dead lettered, messages that are not done with will be redelivered
+func (w *worker) copy(msg *connector.Msg) bool {
 	obs := prometheus.NewTimer(processTime.WithLabelValues(w.name, w.config.Name))
 	defer obs.ObserveDuration()
 
 	receivedCtr.WithLabelValues(w.name, w.config.Name).Inc()
 	receivedBytesCtr.WithLabelValues(w.name, w.config.Name).Add(float64(len(msg.Data)))
 
-	limiter.Process(msg, func(msg *stan.Msg, process bool) error {
-		if process {
-			err := w.to.Publish(msg.Subject, msg.Data)
-			if err != nil {
-				w.log.Errorf("Could not publish message %d: %s", msg.Sequence, err)
-				failedCtr.WithLabelValues(w.name, w.config.Name).Inc()
-				return err
-			}
+	if w.dedupe != nil && w.dedupe.Copied(msg) {
+		w.log.Debugf("Skipping message %d that was already copied", msg.Sequence)
+		duplicateSkippedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+		w.ack(msg)
+		return true
+	}
 
-			w.log.Debugf("Copied %d bytes in sequence %d from %s to %s", len(msg.Data), msg.Sequence, w.config.SourceURL, w.config.TargetURL)
+	var env *envelope.V1
+	var err error
 
-			copiedBytesCtr.WithLabelValues(w.name, w.config.Name).Add(float64(len(msg.Data)))
-			copiedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+	if w.wrapper != nil {
+		msg, env, err = w.wrapper.Unwrap(msg)
+		if err != nil {
+			w.log.Errorf("Could not unwrap message %d: %s", msg.Sequence, err)
+			return w.rejected(msg, err)
 		}
+	}
+
+	if w.loop != nil && w.loop.Loops(env) {
+		w.log.Debugf("Skipping message %d from %s that was already copied from a target", msg.Sequence, env.Origin)
+		loopSkippedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+		w.ack(msg)
+		return true
+	}
 
-		sequenceGauge.WithLabelValues(w.name, w.config.Name).Set(float64(msg.Sequence))
+	// messages the filter cannot be evaluated on, like ones without the fields it
+	// compares, would fail the same way on every redelivery so they do not match
+	match, err := w.filter.Match(msg)
+	if err != nil {
+		w.log.Warnf("Skipping message %d that the filter could not be evaluated on: %s", msg.Sequence, err)
+		filterSkippedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+		w.ack(msg)
+		return true
+	}
 
-		err := msg.Ack()
+	if !match {
+		w.log.Debugf("Skipping message %d that does not match the filter", msg.Sequence)
+		filterSkippedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+		w.ack(msg)
+		return true
+	}
+
+	done := false
+
+	w.limiter.Process(msg, func(msg *connector.Msg, process bool) error {
+		if !process {
+			done = true
+			return w.ack(msg)
+		}
+
+		// rendering the subject fails the same way on every redelivery
+		subjects, err := w.subjects(msg)
 		if err != nil {
-			ackFailedCtr.WithLabelValues(w.name, w.config.Name).Inc()
-			w.log.Errorf("Could not ack message %d: %s", msg.Sequence, err)
+			w.log.Errorf("Could not determine target subject for message %d: %s", msg.Sequence, err)
+			done = w.rejected(msg, err)
+			return err
+		}
+
+		// asynchronously published messages are never partitioned so they are
+		// done with once published, failures are handled when the target responds
+		if w.pending != nil {
+			done = true
+			return w.publishAsync(msg, env, subjects)
 		}
 
+		err = w.publish(msg, env, subjects)
+		if err != nil {
+			done = w.failed(msg, err)
+			return err
+		}
+
+		done = true
+		return w.ack(msg)
+	})
+
+	return done
+}
+
+// subjects renders the subject msg is published to on each target
+func (w *worker) subjects(msg *connector.Msg) ([]string, error) {
+	subjects := make([]string, len(w.targets))
+
+	for i, t := range w.targets {
+		subject, err := w.subject.Subject(msg, t.id)
+		if err != nil {
+			return nil, err
+		}
+
+		subjects[i] = subject
+	}
+
+	return subjects, nil
+}
+
+func (w *worker) publish(msg *connector.Msg, env *envelope.V1, subjects []string) error {
+	data, compressed, err := w.payload(msg, env)
+	if err != nil {
+		w.log.Errorf("Could not prepare message %d for publishing: %s", msg.Sequence, err)
 		return err
+	}
+
+	err = w.wait(data)
+	if err != nil {
+		return err
+	}
+
+	result := make(chan error, 1)
+	f := newFanout(w.config.TargetPolicy, len(w.targets), func(err error) {
+		result <- err
 	})
+
+	for i, t := range w.targets {
+		go func(t *target, subject string) {
+			f.Result(w.published(t, msg, w.publishTo(t, subject, msg, data)))
+		}(t, subjects[i])
+	}
+
+	err = <-result
+	if err != nil {
+		w.log.Errorf("Could not publish message %d: %s", msg.Sequence, err)
+		return err
+	}
+
+	w.copied(msg, subjects, compressed)
+
+	return nil
 }
 
+// publishAsync publishes msg without waiting for the target to acknowledge it, the
+// source message is acked once the target ack is received. Publishing blocks when
+// too many publishes are waiting for acknowledgement
+func (w *worker) publishAsync(msg *connector.Msg, env *envelope.V1, subjects []string) error {
+	data, compressed, err := w.payload(msg, env)
+	if err != nil {
+		w.log.Errorf("Could not prepare message %d for publishing: %s", msg.Sequence, err)
+		w.failed(msg, err)
+		return err
+	}
+
+	err = w.wait(data)
+	if err != nil {
+		return err
+	}
+
+	w.pending <- struct{}{}
+	w.track(1)
+
+	f := newFanout(w.config.TargetPolicy, len(w.targets), func(err error) {
+		defer w.track(-1)
+		<-w.pending
+
+		if err != nil {
+			w.log.Errorf("Could not publish message %d: %s", msg.Sequence, err)
+			w.failed(msg, err)
+			return
+		}
+
+		w.copied(msg, subjects, compressed)
+		w.ack(msg)
+	})
+
+	for i, t := range w.targets {
+		t := t
+
+		err = t.conn.PublishAsync(subjects[i], data, func(err error) {
+			f.Result(w.published(t, msg, err))
+		})
+		if err != nil {
+			f.Result(w.published(t, msg, err))
+		}
+	}
+
+	return nil
+}
+
+// wait blocks while the rate limits are exceeded, the message is not acked
+// when waiting is interrupted so it will be redelivered
+func (w *worker) wait(data []byte) error {
+	if w.throttle == nil {
+		return nil
+	}
+
+	waited, err := w.throttle.Wait(w.ctx, len(data))
+	throttledTime.WithLabelValues(w.name, w.config.Name).Add(waited.Seconds())
+	if err != nil {
+		w.log.Warnf("Stopped waiting for the rate limit: %s", err)
+	}
+
+	return err
+}
+
+// publishTo publishes data to a single target, with de-duplication enabled the
+// target is given an id unique to msg to allow it to discard duplicates
+func (w *worker) publishTo(t *target, subject string, msg *connector.Msg, data []byte) error {
+	if w.dedupe != nil {
+		return t.conn.PublishWithID(subject, w.dedupe.MsgID(msg), data)
+	}
+
+	return t.conn.Publish(subject, data)
+}
+
+// payload is the data to publish for msg, wrapped in env when it is set, and the size
+// of the data after compressing it or 0 when it was not compressed
+func (w *worker) payload(msg *connector.Msg, env *envelope.V1) ([]byte, int, error) {
+	if env == nil {
+		return msg.Data, 0, nil
+	}
+
+	return w.wrapper.wrap(env)
+}
+
+// published records the outcome of publishing msg to a single target
+func (w *worker) published(t *target, msg *connector.Msg, err error) error {
+	if err != nil {
+		w.log.Warnf("Could not publish message %d to target %s: %s", msg.Sequence, t.name, err)
+		targetFailedCtr.WithLabelValues(w.name, w.config.Name, t.name).Inc()
+
+		return fmt.Errorf("%s: %s", t.name, err)
+	}
+
+	targetCopiedCtr.WithLabelValues(w.name, w.config.Name, t.name).Inc()
+
+	return nil
+}
+
+func (w *worker) copied(msg *connector.Msg, subjects []string, compressed int) {
+	w.log.Debugf("Copied %d bytes in sequence %d from %s to %d target(s) on %s", len(msg.Data), msg.Sequence, w.config.SourceURL, len(w.targets), strings.Join(subjects, ", "))
+
+	copiedBytesCtr.WithLabelValues(w.name, w.config.Name).Add(float64(len(msg.Data)))
+	copiedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+
+	if !msg.Timestamp.IsZero() {
+		observeLatency(w.name, w.config.Name, time.Since(msg.Timestamp).Seconds())
+	}
+
+	if compressed > 0 {
+		compressedBytesCtr.WithLabelValues(w.name, w.config.Name).Add(float64(compressed))
+	}
+
+	if w.failures != nil {
+		w.failures.Forget(msg)
+	}
+
+	if w.lag != nil {
+		w.lag.Copied(msg)
+	}
+
+	if w.dedupe != nil {
+		err := w.dedupe.Record(msg)
+		if err != nil {
+			w.log.Errorf("Could not record sequence %d as copied: %s", msg.Sequence, err)
+		}
+	}
+}
+
+// failed records that msg failed to copy and reports if it was dead lettered
+func (w *worker) failed(msg *connector.Msg, err error) bool {
+	failedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+	return w.deadLetter(msg, err)
+}
+
+// deadLetter publishes msg to the dead letter subject and acks it once
+// it failed to copy the configured amount of times
+func (w *worker) deadLetter(msg *connector.Msg, reason error) bool {
+	if w.failures == nil {
+		return false
+	}
+
+	attempts := w.failures.Failed(msg)
+	if attempts < w.config.DeadLetter.Attempts {
+		return false
+	}
+
+	return w.publishDeadLetter(msg, attempts, reason)
+}
+
+// rejected handles messages that will never be copied, like envelopes that cannot be
+// decoded or decrypted, they are published to the dead letter subject without retrying
+// and when no dead letters are configured they are acked so they are not redelivered
+func (w *worker) rejected(msg *connector.Msg, reason error) bool {
+	failedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+
+	if w.failures == nil {
+		w.log.Warnf("Discarding message %d that cannot be copied", msg.Sequence)
+		w.ack(msg)
+		return true
+	}
+
+	return w.publishDeadLetter(msg, 1, reason)
+}
+
+// publishDeadLetter publishes msg to the dead letter subject and acks it, msg is
+// left to be redelivered when publishing fails
+func (w *worker) publishDeadLetter(msg *connector.Msg, attempts int, reason error) bool {
+	dl, err := newDeadLetter(w.config.Name, msg, attempts, reason)
+	if err != nil {
+		w.log.Errorf("Could not create dead letter for message %d: %s", msg.Sequence, err)
+		return false
+	}
+
+	conn := w.targets[0].conn
+	if w.config.DeadLetter.Cluster == "source" {
+		conn = w.from
+	}
+
+	err = conn.Publish(w.config.DeadLetter.Subject, dl)
+	if err != nil {
+		w.log.Errorf("Could not publish message %d to dead letter subject %s: %s", msg.Sequence, w.config.DeadLetter.Subject, err)
+		return false
+	}
+
+	w.log.Warnf("Published message %d to dead letter subject %s after %d failed attempts", msg.Sequence, w.config.DeadLetter.Subject, attempts)
+	deadLetterCtr.WithLabelValues(w.name, w.config.Name).Inc()
+
+	w.failures.Forget(msg)
+	w.ack(msg)
+
+	return true
+}
+
+func (w *worker) ack(msg *connector.Msg) error {
+	sequenceGauge.WithLabelValues(w.name, w.config.Name).Set(float64(msg.Sequence))
+	atomic.StoreUint64(&w.lastSequence, msg.Sequence)
+	atomic.StoreInt64(&w.lastAcked, time.Now().UnixNano())
+
+	err := msg.Ack()
+	if err != nil {
+		ackFailedCtr.WithLabelValues(w.name, w.config.Name).Inc()
+		w.log.Errorf("Could not ack message %d: %s", msg.Sequence, err)
+	}
+
+	return err
+}
+
+// subscribe subscribes to the source, in partitioned mode only the first worker
+// subscribes and dispatches messages to the partition queues of all workers,
+// it should be called with mu held
 func (w *worker) subscribe() error {
-	opts := []stan.SubscriptionOption{
-		stan.DurableName(w.config.Name),
-		stan.DeliverAllAvailable(),
-		stan.SetManualAckMode(),
-		stan.MaxInflight(10),
+	var err error
+
+	switch {
+	case w.parts == nil:
+		err = w.from.Subscribe(w.config.Topic, w.config.QueueGroup, w.copyf, w.subOpts)
+
+	case w.index > 0:
+		return nil
+
+	default:
+		err = w.from.Subscribe(w.config.Topic, w.config.QueueGroup, func(msg *connector.Msg) {
+			if w.receiving() {
+				w.parts.Dispatch(w.ctx, msg)
+			}
+		}, w.subOpts)
 	}
 
-	return w.from.Subscribe(w.config.Topic, w.config.QueueGroup, w.copyf, opts...)
+	return err
+}
+
+// partition copies messages dispatched to this worker in the order they were received,
+// dispatched messages are copied while the worker drains
+func (w *worker) partition(queue chan *connector.Msg) {
+	// once a message is left to be redelivered while stopping the messages
+	// after it are too, copying them would publish them before it
+	abandoned := false
+
+	next := func(msg *connector.Msg) {
+		defer w.parts.Track(-1)
+
+		if abandoned && !w.receiving() {
+			w.log.Debugf("Not copying message %d dispatched after a message that will be redelivered", msg.Sequence)
+			return
+		}
+
+		abandoned = !w.copyInOrder(msg)
+	}
+
+	for {
+		select {
+		case msg := <-queue:
+			next(msg)
+
+		case <-w.ctx.Done():
+			for {
+				select {
+				case msg := <-queue:
+					next(msg)
+				default:
+					return
+				}
+			}
+		}
+	}
 }
 
 func (w *worker) connect(ctx context.Context) error {
-	wg := &sync.WaitGroup{}
+	tls := w.tls
+	if w.config.DisableSourceTLS {
+		tls = false
+	}
 
-	wg.Add(1)
-	go func(wg *sync.WaitGroup) {
-		defer wg.Done()
+	from := connector.NewStream(w.name, tls, connector.Source, w.config, w.log)
+
+	tls = w.tls
+	if w.config.DisableTargetTLS {
+		tls = false
+	}
 
-		tls := w.tls
-		if w.config.DisableSourceTLS {
-			tls = false
+	targets := make([]*target, len(w.config.Targets))
+	for i, tc := range w.config.Targets {
+		targets[i] = &target{
+			name: tc.Name,
+			id:   tc.ID,
+			conn: connector.NewTarget(w.name, tls, tc, w.config, w.log),
 		}
+	}
 
-		w.from = connector.New(w.name, tls, connector.Source, w.config, w.log)
-		w.from.Connect(ctx)
-	}(wg)
+	// the connections are set before connecting so their state can be reported
+	w.mu.Lock()
+	w.from = from
+	w.targets = targets
+	w.mu.Unlock()
+
+	wg := &sync.WaitGroup{}
 
 	wg.Add(1)
 	go func(wg *sync.WaitGroup) {
 		defer wg.Done()
-
-		tls := w.tls
-		if w.config.DisableTargetTLS {
-			tls = false
-		}
-
-		w.to = connector.New(w.name, tls, connector.Target, w.config, w.log)
-		w.to.Connect(ctx)
+		from.Connect(ctx)
 	}(wg)
 
+	for _, t := range targets {
+		wg.Add(1)
+		go func(wg *sync.WaitGroup, t *target) {
+			defer wg.Done()
+			t.conn.Connect(ctx)
+		}(wg, t)
+	}
+
 	wg.Wait()
 
-	if w.from == nil || w.to == nil {
+	if w.from == nil || len(w.targets) == 0 {
 		return fmt.Errorf("could not establish initial connection to Stream brokers")
 	}
 
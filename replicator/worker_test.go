@@ -0,0 +1,365 @@
+package replicator
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/choria-io/stream-replicator/envelope"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+)
+
+type stubStream struct {
+	closed     bool
+	subscribes int
+	state      string
+	down       time.Duration
+	delay      time.Duration
+	published  int64
+	onClose    func()
+
+	// fail is how many publishes fail before publishing succeeds, accessed atomically
+	fail int32
+
+	mu       sync.Mutex
+	data     []string
+	subjects []string
+}
+
+func (s *stubStream) Connect(ctx context.Context) {}
+func (s *stubStream) Subscribe(subject string, qgroup string, cb connector.MsgHandler, opts connector.SubscribeOptions) error {
+	s.subscribes++
+	return nil
+}
+func (s *stubStream) Publish(subject string, data []byte) error {
+	time.Sleep(s.delay)
+
+	if atomic.AddInt32(&s.fail, -1) >= 0 {
+		return fmt.Errorf("simulated failure")
+	}
+
+	s.mu.Lock()
+	s.data = append(s.data, string(data))
+	s.subjects = append(s.subjects, subject)
+	s.mu.Unlock()
+
+	atomic.AddInt64(&s.published, 1)
+	return nil
+}
+func (s *stubStream) copied() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]string{}, s.data...)
+}
+func (s *stubStream) PublishWithID(subject string, id string, data []byte) error {
+	return nil
+}
+func (s *stubStream) PublishAsync(subject string, data []byte, cb func(err error)) error {
+	return nil
+}
+func (s *stubStream) CloseSubscriptions() error {
+	if s.onClose != nil {
+		s.onClose()
+	}
+
+	s.closed = true
+	return nil
+}
+func (s *stubStream) State() string {
+	if s.state == "" {
+		return connector.StateConnected
+	}
+
+	return s.state
+}
+func (s *stubStream) Disconnected() time.Duration { return s.down }
+func (s *stubStream) Close() error                { return nil }
+
+var _ = Describe("Worker", func() {
+	var (
+		w    *worker
+		from *stubStream
+		conf func() *config.TopicConf
+	)
+
+	BeforeEach(func() {
+		from = &stubStream{}
+		w = &worker{
+			name:   "test",
+			from:   from,
+			config: &config.TopicConf{Name: "test"},
+			drain:  time.Second,
+			log:    logrus.WithField("test", true),
+		}
+
+		conf = func() *config.TopicConf {
+			return &config.TopicConf{
+				Topic:     "acme.cmdb",
+				SourceID:  "dc1",
+				TargetURL: "nats://localhost:4222",
+				TargetID:  "dc2",
+				Name:      "cmdb",
+			}
+		}
+	})
+
+	// setup creates the first worker of a copier set up with topic, it copies
+	// from the from stub to the returned target stub
+	setup := func(topic *config.TopicConf) (*worker, *stubStream) {
+		c := &Copier{}
+		Expect(c.Setup("test", topic)).To(Succeed())
+
+		to := &stubStream{}
+		sw := newWorker(0, c)
+		sw.ctx = context.Background()
+		sw.from = from
+		sw.targets = []*target{{name: "dc2", id: "dc2", conn: to}}
+
+		return sw, to
+	}
+
+	Describe("pause", func() {
+		It("Should close subscriptions and resume should subscribe again", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			w.ctx = ctx
+			Expect(w.resume()).To(Succeed())
+			Expect(from.subscribes).To(Equal(1))
+
+			Expect(w.pause()).To(Succeed())
+			Expect(from.closed).To(BeTrue())
+			Expect(w.status().Paused).To(BeTrue())
+
+			Expect(w.pause()).To(Succeed())
+			Expect(w.resume()).To(Succeed())
+			Expect(from.subscribes).To(Equal(2))
+			Expect(w.status().Paused).To(BeFalse())
+
+			Expect(w.resume()).To(Succeed())
+			Expect(from.subscribes).To(Equal(2))
+		})
+
+		It("Should not resume once shutting down", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			w.ctx = ctx
+			w.paused = true
+			Expect(w.resume()).To(Succeed())
+			Expect(from.subscribes).To(Equal(0))
+		})
+	})
+
+	Describe("status", func() {
+		It("Should report connection states and the last sequence", func() {
+			w.targets = []*target{{name: "right", conn: &stubStream{}}}
+			w.ack(&connector.Msg{Sequence: 10})
+
+			s := w.status()
+			Expect(s.Source).To(Equal(connector.StateConnected))
+			Expect(s.Targets).To(Equal(map[string]string{"right": connector.StateConnected}))
+			Expect(s.LastSequence).To(Equal(uint64(10)))
+		})
+	})
+
+	Describe("drainInflight", func() {
+		It("Should stop copying and wait for in-flight messages", func() {
+			atomic.AddInt64(&w.inflight, 1)
+
+			go func() {
+				time.Sleep(200 * time.Millisecond)
+				atomic.AddInt64(&w.inflight, -1)
+			}()
+
+			start := time.Now()
+			w.drainInflight()
+
+			Expect(w.receiving()).To(BeFalse())
+			Expect(from.closed).To(BeFalse())
+			Expect(time.Since(start)).To(BeNumerically(">=", 200*time.Millisecond))
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+		})
+
+		It("Should give up after the drain timeout", func() {
+			w.drain = 100 * time.Millisecond
+			atomic.AddInt64(&w.inflight, 1)
+
+			start := time.Now()
+			w.drainInflight()
+
+			Expect(time.Since(start)).To(BeNumerically("~", 100*time.Millisecond, 50*time.Millisecond))
+		})
+	})
+
+	Describe("filtering", func() {
+		It("Should ack messages the filter cannot be evaluated on without copying them", func() {
+			topic := conf()
+			topic.Filter = "data.size > 10"
+			fw, to := setup(topic)
+
+			fw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte(`{"name":"node1"}`), Sequence: 1})
+			Expect(atomic.LoadUint64(&fw.lastSequence)).To(Equal(uint64(1)))
+
+			fw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte("not json"), Sequence: 2})
+			Expect(atomic.LoadUint64(&fw.lastSequence)).To(Equal(uint64(2)))
+
+			fw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte(`{"size":20}`), Sequence: 3})
+			Expect(atomic.LoadUint64(&fw.lastSequence)).To(Equal(uint64(3)))
+
+			Expect(atomic.LoadInt64(&to.published)).To(BeNumerically("==", 1))
+		})
+	})
+
+	Describe("rejected", func() {
+		var (
+			broken *connector.Msg
+			topic  *config.TopicConf
+		)
+
+		BeforeEach(func() {
+			broken = &connector.Msg{Subject: "acme.cmdb", Data: []byte(`{"$schema":"` + envelope.Schema + `", broken`), Sequence: 5}
+			topic = conf()
+			topic.Envelope = "json"
+		})
+
+		It("Should ack envelopes that cannot be unwrapped", func() {
+			rw, to := setup(topic)
+
+			rw.copyf(broken)
+			Expect(atomic.LoadUint64(&rw.lastSequence)).To(Equal(uint64(5)))
+			Expect(atomic.LoadInt64(&to.published)).To(BeZero())
+		})
+
+		It("Should dead letter envelopes that cannot be unwrapped without retrying", func() {
+			topic.DeadLetter = &config.DeadLetterConf{Subject: "sr.dead"}
+			rw, to := setup(topic)
+
+			rw.copyf(broken)
+			Expect(atomic.LoadUint64(&rw.lastSequence)).To(Equal(uint64(5)))
+			Expect(atomic.LoadInt64(&from.published)).To(BeNumerically("==", 1))
+			Expect(atomic.LoadInt64(&to.published)).To(BeZero())
+		})
+	})
+
+	Describe("target subjects", func() {
+		var topic *config.TopicConf
+
+		BeforeEach(func() {
+			topic = conf()
+			topic.TargetSubject = `{{.TargetID}}.{{.Lookup "site"}}.cmdb`
+		})
+
+		It("Should render the subject for every target", func() {
+			sw, to := setup(topic)
+			other := &stubStream{}
+			sw.targets = append(sw.targets, &target{name: "dc3", id: "dc3", conn: other})
+
+			sw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte(`{"site":"lon"}`), Sequence: 1})
+			Expect(to.subjects).To(Equal([]string{"dc2.lon.cmdb"}))
+			Expect(other.subjects).To(Equal([]string{"dc3.lon.cmdb"}))
+		})
+
+		It("Should ack messages without a valid subject", func() {
+			sw, to := setup(topic)
+
+			sw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte(`{}`), Sequence: 1})
+			Expect(atomic.LoadUint64(&sw.lastSequence)).To(Equal(uint64(1)))
+			Expect(atomic.LoadInt64(&to.published)).To(BeZero())
+		})
+
+		It("Should dead letter messages without a valid subject without retrying", func() {
+			topic.DeadLetter = &config.DeadLetterConf{Subject: "sr.dead"}
+			sw, to := setup(topic)
+
+			sw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte(`{}`), Sequence: 1})
+			Expect(atomic.LoadUint64(&sw.lastSequence)).To(Equal(uint64(1)))
+			Expect(from.subjects).To(Equal([]string{"sr.dead"}))
+			Expect(atomic.LoadInt64(&to.published)).To(BeZero())
+		})
+	})
+
+	Describe("copying while stopping", func() {
+		var (
+			to *stubStream
+			cw *worker
+		)
+
+		BeforeEach(func() {
+			cw, to = setup(conf())
+			to.delay = 200 * time.Millisecond
+			Expect(cw.resume()).To(Succeed())
+		})
+
+		// copies msg in the background and waits till it is being published
+		copying := func(seq uint64) {
+			go cw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte("{}"), Sequence: seq})
+			Eventually(func() int64 { return atomic.LoadInt64(&cw.inflight) }).Should(BeNumerically("==", 1))
+		}
+
+		It("Should ack messages copied while draining and not copy new ones", func() {
+			copying(5)
+			cw.drainInflight()
+
+			Expect(atomic.LoadInt64(&to.published)).To(BeNumerically("==", 1))
+			Expect(atomic.LoadUint64(&cw.lastSequence)).To(Equal(uint64(5)))
+
+			cw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte("{}"), Sequence: 6})
+			Expect(atomic.LoadInt64(&to.published)).To(BeNumerically("==", 1))
+			Expect(atomic.LoadUint64(&cw.lastSequence)).To(Equal(uint64(5)))
+		})
+
+		It("Should ack messages being copied before pausing closes subscriptions", func() {
+			var acked uint64
+			from.onClose = func() { acked = atomic.LoadUint64(&cw.lastSequence) }
+
+			copying(5)
+			Expect(cw.pause()).To(Succeed())
+			Expect(from.closed).To(BeTrue())
+			Expect(acked).To(Equal(uint64(5)))
+
+			cw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte("{}"), Sequence: 6})
+			Expect(atomic.LoadInt64(&to.published)).To(BeNumerically("==", 1))
+
+			Expect(cw.resume()).To(Succeed())
+			cw.copyf(&connector.Msg{Subject: "acme.cmdb", Data: []byte("{}"), Sequence: 7})
+			Expect(atomic.LoadInt64(&to.published)).To(BeNumerically("==", 2))
+			Expect(atomic.LoadUint64(&cw.lastSequence)).To(Equal(uint64(7)))
+		})
+	})
+
+	Describe("partition", func() {
+		It("Should retry failed messages before copying later messages with the same key", func() {
+			topic := conf()
+			topic.Workers = 1
+			topic.Partitioned = true
+			topic.Inspect = "sender"
+			pw, to := setup(topic)
+			to.fail = 1
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			pw.ctx = ctx
+			go pw.partition(pw.parts.Queue(0))
+
+			expected := []string{}
+			for i := 1; i <= 3; i++ {
+				data := fmt.Sprintf(`{"sender":"node1","seq":%d}`, i)
+				expected = append(expected, data)
+				pw.parts.Dispatch(ctx, &connector.Msg{Subject: "acme.cmdb", Sequence: uint64(i), Data: []byte(data)})
+			}
+
+			Eventually(to.copied, 5*time.Second).Should(Equal(expected))
+			Eventually(pw.parts.Busy).Should(BeZero())
+			Expect(atomic.LoadUint64(&pw.lastSequence)).To(Equal(uint64(3)))
+		})
+	})
+})
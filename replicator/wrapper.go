@@ -0,0 +1,149 @@
+package replicator
+
+import (
+	"fmt"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/choria-io/stream-replicator/envelope"
+)
+
+// wrapper wraps copied messages in an envelope holding their origin metadata,
+// messages that were already wrapped by another replicator keep their origin
+// and get the source added as a hop.  When decompressing the original data is
+// published without an envelope
+type wrapper struct {
+	format     string
+	compress   string
+	decompress bool
+	crypter    *crypter
+	source     string
+	name       string
+}
+
+func newWrapper(c *config.TopicConf) (*wrapper, error) {
+	crypter, err := newCrypter(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Decompress {
+		if c.Envelope != "" || c.Compress != "" || c.Bidirectional {
+			return nil, fmt.Errorf("decompress cannot be combined with envelope, compress or bidirectional")
+		}
+
+		if crypter != nil && crypter.encrypt {
+			return nil, fmt.Errorf("decompress cannot be combined with encryption")
+		}
+
+		return &wrapper{decompress: true, crypter: crypter, source: c.SourceID, name: c.Name}, nil
+	}
+
+	if c.Compress != "" && !envelope.ValidCompression(c.Compress) {
+		return nil, fmt.Errorf("invalid compression %q, valid algorithms are %s, %s and %s", c.Compress, envelope.GzipCompression, envelope.ZstdCompression, envelope.S2Compression)
+	}
+
+	if c.Envelope == "" && c.Bidirectional {
+		c.Envelope = envelope.JSONFormat
+	}
+
+	// compressed and encrypted data is binary so avoid the base64 overhead of json envelopes
+	if c.Envelope == "" && (c.Compress != "" || crypter != nil) {
+		c.Envelope = envelope.RawFormat
+	}
+
+	switch c.Envelope {
+	case "":
+		return nil, nil
+	case envelope.JSONFormat, envelope.RawFormat:
+	default:
+		return nil, fmt.Errorf("invalid envelope format %q, valid formats are %s and %s", c.Envelope, envelope.JSONFormat, envelope.RawFormat)
+	}
+
+	return &wrapper{
+		format:   c.Envelope,
+		compress: c.Compress,
+		crypter:  crypter,
+		source:   c.SourceID,
+		name:     c.Name,
+	}, nil
+}
+
+// Unwrap extracts the original message from msg when it was copied by another replicator,
+// messages that were published directly on the source get a new envelope
+func (w *wrapper) Unwrap(msg *connector.Msg) (*connector.Msg, *envelope.V1, error) {
+	if !envelope.IsEnvelope(msg.Data) {
+		env := envelope.New(w.source, msg.Data)
+		env.Subject = msg.Subject
+		env.Sequence = msg.Sequence
+		env.Timestamp = msg.Timestamp
+		env.Replicator = w.name
+
+		return msg, env, nil
+	}
+
+	env, err := envelope.Unwrap(msg.Data)
+	if err != nil {
+		return msg, nil, err
+	}
+
+	if env.Encryption != "" && w.crypter != nil && !w.crypter.encrypt {
+		err = w.crypter.Decrypt(env)
+		if err != nil {
+			return msg, nil, err
+		}
+	}
+
+	unwrapped := *msg
+	unwrapped.Data = env.Data
+
+	return &unwrapped, env, nil
+}
+
+// Wrap records the source as a hop, compresses the data and encodes the envelope for publishing
+func (w *wrapper) Wrap(env *envelope.V1) ([]byte, error) {
+	data, _, err := w.wrap(env)
+	return data, err
+}
+
+// wrap is Wrap that also reports the size of the data right after compressing it, before
+// it is encrypted and encoded, the size is 0 when the data was not compressed
+func (w *wrapper) wrap(env *envelope.V1) ([]byte, int, error) {
+	if w.decompress {
+		if env.Encryption != "" {
+			return nil, 0, fmt.Errorf("cannot publish data encrypted using %s without decrypting it", env.Encryption)
+		}
+
+		return env.Data, 0, nil
+	}
+
+	env.AddHop(w.source)
+
+	// encrypted data passing through is copied as is
+	if env.Encryption != "" {
+		data, err := env.Encode(w.format)
+		return data, 0, err
+	}
+
+	compressed := 0
+
+	if w.compress != "" {
+		err := env.Compress(w.compress)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		compressed = len(env.Data)
+	}
+
+	if w.crypter != nil && w.crypter.encrypt {
+		err := w.crypter.Encrypt(env)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+
+	data, err := env.Encode(w.format)
+
+	return data, compressed, err
+}
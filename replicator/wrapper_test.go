@@ -0,0 +1,138 @@
+package replicator
+
+import (
+	"time"
+
+	"github.com/choria-io/stream-replicator/config"
+	"github.com/choria-io/stream-replicator/connector"
+	"github.com/choria-io/stream-replicator/envelope"
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Wrapper", func() {
+	var (
+		conf *config.TopicConf
+		msg  *connector.Msg
+	)
+
+	BeforeEach(func() {
+		conf = &config.TopicConf{SourceID: "dc1", Name: "cmdb", Envelope: "json"}
+		msg = &connector.Msg{Subject: "acme.cmdb", Sequence: 10, Timestamp: time.Unix(1516987895, 0).UTC(), Data: []byte(`{"hello":"world"}`)}
+	})
+
+	It("Should only be created when an envelope is configured", func() {
+		conf.Envelope = ""
+		w, err := newWrapper(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w).To(BeNil())
+
+		conf.Bidirectional = true
+		w, err = newWrapper(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.format).To(Equal("json"))
+
+		conf.Envelope = "xml"
+		_, err = newWrapper(conf)
+		Expect(err).To(MatchError(`invalid envelope format "xml", valid formats are json and raw`))
+	})
+
+	It("Should compress messages", func() {
+		conf.Envelope = ""
+		conf.Compress = "zstd"
+
+		w, err := newWrapper(conf)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.format).To(Equal("raw"))
+
+		_, env, err := w.Unwrap(msg)
+		Expect(err).ToNot(HaveOccurred())
+
+		b, compressed, err := w.wrap(env)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(compressed).To(Equal(len(env.Data)))
+		Expect(compressed).To(BeNumerically("<", len(b)))
+
+		env, err = envelope.Unwrap(b)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(env.Data).To(Equal(msg.Data))
+
+		conf.Compress = "lz4"
+		_, err = newWrapper(conf)
+		Expect(err).To(MatchError(`invalid compression "lz4", valid algorithms are gzip, zstd and s2`))
+	})
+
+	It("Should publish the original data when decompressing", func() {
+		env := envelope.New("dc1", msg.Data)
+		Expect(env.Compress("gzip")).To(Succeed())
+		b, err := env.Raw()
+		Expect(err).ToNot(HaveOccurred())
+
+		conf.Decompress = true
+		_, err = newWrapper(conf)
+		Expect(err).To(MatchError("decompress cannot be combined with envelope, compress or bidirectional"))
+
+		conf.Envelope = ""
+		w, err := newWrapper(conf)
+		Expect(err).ToNot(HaveOccurred())
+
+		m, env, err := w.Unwrap(&connector.Msg{Data: b})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(m.Data).To(Equal(msg.Data))
+
+		b, err = w.Wrap(env)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(b).To(Equal(msg.Data))
+
+		_, env, err = w.Unwrap(msg)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Wrap(env)).To(Equal(msg.Data))
+	})
+
+	for _, format := range []string{"json", "raw"} {
+		format := format
+
+		It("Should wrap messages in the "+format+" format", func() {
+			conf.Envelope = format
+			w, err := newWrapper(conf)
+			Expect(err).ToNot(HaveOccurred())
+
+			m, env, err := w.Unwrap(msg)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m).To(Equal(msg))
+
+			b, err := w.Wrap(env)
+			Expect(err).ToNot(HaveOccurred())
+
+			env, err = envelope.Unwrap(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env.Origin).To(Equal("dc1"))
+			Expect(env.Subject).To(Equal("acme.cmdb"))
+			Expect(env.Sequence).To(Equal(uint64(10)))
+			Expect(env.Timestamp).To(Equal(msg.Timestamp))
+			Expect(env.Replicator).To(Equal("cmdb"))
+			Expect(env.HopCount).To(Equal(1))
+			Expect(env.Data).To(Equal(msg.Data))
+
+			// copying it again keeps the origin and adds a hop
+			conf.SourceID = "dc2"
+			w, err = newWrapper(conf)
+			Expect(err).ToNot(HaveOccurred())
+
+			m, env, err = w.Unwrap(&connector.Msg{Subject: "acme.cmdb", Sequence: 1, Data: b})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(m.Data).To(Equal(msg.Data))
+			Expect(m.Sequence).To(Equal(uint64(1)))
+
+			b, err = w.Wrap(env)
+			Expect(err).ToNot(HaveOccurred())
+
+			env, err = envelope.Unwrap(b)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(env.Origin).To(Equal("dc1"))
+			Expect(env.Sequence).To(Equal(uint64(10)))
+			Expect(env.Hops).To(Equal([]string{"dc1", "dc2"}))
+			Expect(env.HopCount).To(Equal(2))
+		})
+	}
+})